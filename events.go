@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/geriess/gosearch/search"
+)
+
+// schemaVersion is the version of the JSON Lines event schema. It changes
+// only when a field is removed or changes meaning; new fields may be added.
+const schemaVersion = 1
+
+// eventHeader starts every event
+type eventHeader struct {
+	Schema int    `json:"schema"`
+	Event  string `json:"event"` // begin, match, skip, error or summary
+}
+
+type beginEvent struct {
+	eventHeader
+	Time    *time.Time `json:"time"` // null with -sort
+	Keyword string     `json:"keyword"`
+	Path    string     `json:"path"`  // path of a single root, or root labels
+	Roots   []string   `json:"roots"` // root labels
+}
+
+type matchEvent struct {
+	eventHeader
+	Type      string          `json:"type"` // file or folder
+	Root      string          `json:"root"`
+	Path      string          `json:"path"`
+	Name      string          `json:"name"`
+	Size      int64           `json:"size"`
+	ModTime   time.Time       `json:"modTime"`
+	NameMatch bool            `json:"nameMatch"`         // the name matched
+	Matches   []matchLocation `json:"matches,omitempty"` // occurrences in the contents
+}
+
+type matchLocation struct {
+	Line     int            `json:"line"`
+	Column   int            `json:"column"`
+	Offset   int64          `json:"offset"`
+	Location string         `json:"location,omitempty"` // e.g. "page 3" in a document
+	Text     string         `json:"text"`
+	Before   []contextEntry `json:"before,omitempty"`
+	After    []contextEntry `json:"after,omitempty"`
+}
+
+type contextEntry struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+type skipEvent struct {
+	eventHeader
+	Type   string `json:"type"`
+	Root   string `json:"root"`
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+	Size   int64  `json:"size,omitempty"`
+}
+
+type errorEvent struct {
+	eventHeader
+	Root    string `json:"root"`
+	Path    string `json:"path"`
+	Op      string `json:"op"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+type summaryEvent struct {
+	eventHeader
+	Keyword        string         `json:"keyword"`
+	Path           string         `json:"path"`
+	FilesChecked   int            `json:"filesChecked"`
+	FoldersChecked int            `json:"foldersChecked"`
+	FilesFound     int            `json:"filesFound"`
+	FoldersFound   int            `json:"foldersFound"`
+	Errors         int            `json:"errors"`
+	ErrorsByType   map[string]int `json:"errorsByType"`
+	Roots          []rootSummary  `json:"roots"`
+	ElapsedSeconds *float64       `json:"elapsedSeconds"` // null with -sort
+	Complete       bool           `json:"complete"`
+	Stopped        string         `json:"stopped,omitempty"`  // why an incomplete search stopped
+	LastPath       string         `json:"lastPath,omitempty"` // last path an incomplete search reached
+}
+
+type rootSummary struct {
+	Label          string `json:"label"`
+	FilesChecked   int    `json:"filesChecked"`
+	FoldersChecked int    `json:"foldersChecked"`
+	FilesFound     int    `json:"filesFound"`
+	FoldersFound   int    `json:"foldersFound"`
+	Errors         int    `json:"errors"`
+}
+
+// eventWriter writes events to stdout, one JSON object per line
+type eventWriter struct {
+	mu            sync.Mutex
+	out           *bufio.Writer
+	enc           *json.Encoder
+	deterministic bool // write null times, so that runs on an unchanged tree match
+}
+
+// events is set in -jsonl mode
+var events *eventWriter
+
+func newEventWriter(deterministic bool) *eventWriter {
+	out := bufio.NewWriter(os.Stdout)
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	return &eventWriter{out: out, enc: enc, deterministic: deterministic}
+}
+
+func (w *eventWriter) emit(v interface{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.enc.Encode(v)
+}
+
+func (w *eventWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.out.Flush()
+}
+
+func header(event string) eventHeader {
+	return eventHeader{schemaVersion, event}
+}
+
+// emitBegin writes the event that starts the stream
+func (w *eventWriter) emitBegin(start time.Time, keyword string, roots []search.Root) {
+	e := beginEvent{
+		eventHeader: header("begin"),
+		Keyword:     keyword,
+		Path:        rootPaths(roots),
+		Roots:       rootLabels(roots),
+	}
+	if !w.deterministic {
+		e.Time = &start
+	}
+	w.emit(e)
+}
+
+// emitMatch writes a match event for a search result
+func (w *eventWriter) emitMatch(r search.Result) {
+	e := matchEvent{
+		eventHeader: header("match"),
+		Type:        fileType(r),
+		Root:        r.Root,
+		Path:        r.Path,
+		Name:        r.Name,
+		Size:        r.Size,
+		ModTime:     r.ModTime,
+		NameMatch:   r.NameMatch,
+	}
+	for _, m := range r.Matches {
+		e.Matches = append(e.Matches, matchLocation{
+			Line:     m.Line,
+			Column:   m.Column,
+			Offset:   m.Offset,
+			Location: m.Location,
+			Text:     m.Text,
+			Before:   contextEntries(m.Before),
+			After:    contextEntries(m.After),
+		})
+	}
+	w.emit(e)
+}
+
+func contextEntries(lines []search.ContextLine) []contextEntry {
+	var entries []contextEntry
+	for _, l := range lines {
+		entries = append(entries, contextEntry{l.Line, l.Text})
+	}
+	return entries
+}
+
+// emitSkip writes a skip event for a path left out of the search
+func (w *eventWriter) emitSkip(r search.Result) {
+	e := skipEvent{eventHeader: header("skip"), Type: fileType(r), Root: r.Root, Path: r.Path, Reason: r.Reason}
+	if !r.IsDir {
+		e.Size = r.Size
+	}
+	w.emit(e)
+}
+
+// emitError writes an error event for a path that could not be searched
+func (w *eventWriter) emitError(r search.Result) {
+	e := r.Err
+	w.emit(errorEvent{
+		eventHeader: header("error"),
+		Root:        r.Root,
+		Path:        e.Path,
+		Op:          e.Op,
+		Kind:        e.Kind,
+		Message:     e.Err.Error(),
+	})
+}
+
+// emitSummary writes the event that ends the stream
+func (w *eventWriter) emitSummary(keyword, path string, sum search.Summary, stopped string) {
+	e := summaryEvent{
+		eventHeader:    header("summary"),
+		Keyword:        keyword,
+		Path:           path,
+		FilesChecked:   sum.FilesChecked,
+		FoldersChecked: sum.FoldersChecked,
+		FilesFound:     sum.FilesFound,
+		FoldersFound:   sum.FoldersFound,
+		Errors:         sum.Errors,
+		ErrorsByType:   sum.ErrorsByType,
+		Complete:       stopped == "",
+	}
+	if !w.deterministic {
+		elapsed := sum.Elapsed.Seconds()
+		e.ElapsedSeconds = &elapsed
+	}
+	for _, root := range sum.Roots {
+		e.Roots = append(e.Roots, rootSummary{
+			Label:          root.Label,
+			FilesChecked:   root.FilesChecked,
+			FoldersChecked: root.FoldersChecked,
+			FilesFound:     root.FilesFound,
+			FoldersFound:   root.FoldersFound,
+			Errors:         root.Errors,
+		})
+	}
+	if stopped != "" {
+		e.Stopped = stopped
+		e.LastPath = sum.LastPath
+	}
+	w.emit(e)
+}
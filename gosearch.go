@@ -1,41 +1,78 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"runtime"
+	"sort"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/geriess/gosearch/search"
 )
 
 var (
-	inputDir    string         // user input; top-level path to search
-	searchText  string         // user input; keyword to search
-	verbose     bool           // user input; if true displays all paths
-	numFound    int            // # of files matching keyword
-	fileVisit   int            // # of files visited by search
-	dirFound    int            // # of directories matching keyword
-	folderVisit int            // # of folders visited by search
-	wg          sync.WaitGroup // sync goroutines / channels
-	lock        sync.Mutex     // control access to counters (race prevention)
-	maxSize     int64          // max file size
-	json        bool           // output in json if true
-	help        bool           // display help if true
+	inputDirs   stringList    // user input; top-level paths to search
+	filesFrom   string        // user input; file listing paths to search, - for stdin
+	nullSep     bool          // user input; paths in filesFrom end with NUL
+	searchText  string        // user input; keyword to search
+	verbose     bool          // user input; if true displays all paths
+	maxSize     int64         // max file size in MB; 0 means no limit
+	jsonLogs    bool          // output logs in json if true
+	jsonLines   bool          // user input; write results as JSON Lines events
+	help        bool          // display help if true
+	useRegex    bool          // user input; treat keyword as a regular expression
+	matcher     string        // user input; name of the matcher finding terms
+	distance    int           // user input; edits allowed by the fuzzy matcher
+	ignoreCase  bool          // user input; case-insensitive matching
+	useQuery    bool          // user input; keyword is a boolean query
+	scope       string        // user input; where query terms must occur together
+	afterLines  int           // user input; context lines after each match
+	beforeLines int           // user input; context lines before each match
+	aroundLines int           // user input; context lines around each match
+	workers     int           // user input; number of concurrent searches
+	follow      bool          // user input; follow symbolic links
+	maxDepth    int           // user input; levels below the path to walk
+	minDepth    int           // user input; levels below the path to walk without searching
+	xdev        bool          // user input; stay on the file system of the path
+	charDevices int64         // user input; bytes read from each character device
+	noIgnore    bool          // user input; do not read ignore files
+	noExtract   bool          // user input; search documents as raw bytes
+	maxDocSize  int64         // user input; largest document read into memory in MB
+	archDepth   int           // user input; levels of archives whose members are searched
+	includes    stringList    // user input; globs of files to search
+	excludes    stringList    // user input; globs of paths to skip
+	typeNames   string        // user input; comma separated file types to search
+	typeAdds    stringList    // user input; file type definitions
+	typeList    bool          // user input; print file types if true
+	quiet       bool          // user input; no output, stop at first match
+	strict      bool          // user input; abort on the first error
+	sortBy      string        // user input; order of the results
+	timeout     time.Duration // user input; stop the search after this long
 )
 
-// walkresult struct for result document
-type walkresult struct {
-	path    string
-	name    string
-	found   bool
-	isDir   bool
-	size    int64
-	modTime time.Time
+// exit codes, compatible with grep
+const (
+	exitMatch   = 0 // a match was found
+	exitNoMatch = 1 // no match was found
+	exitError   = 2 // an error occurred
+)
+
+// stringList is a flag that can be repeated
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
 
 func usage() {
@@ -45,16 +82,50 @@ func usage() {
 	fmt.Println("==================================")
 	fmt.Println("Usage:")
 	fmt.Println("    gosearch [OPTIONS] -p path -k keyword")
+	fmt.Println("    gosearch [OPTIONS] -k keyword [label=]path...")
+	fmt.Println("    find . -name '*.log' -print0 | gosearch [OPTIONS] -k keyword -files-from - -null")
 	flag.PrintDefaults()
 }
 
 func init() {
 	// flag init
-	flag.StringVar(&inputDir, "p", "", "Path of directory to search")
+	flag.Var(&inputDirs, "p", "Path of directory or file to search, optionally labelled as label=path; repeatable, and paths may also follow the options")
+	flag.StringVar(&filesFrom, "files-from", "", "Search the paths listed in a file, one per line, or - to read them from stdin - optional")
+	flag.BoolVar(&nullSep, "null", false, "Paths given to -files-from end with a NUL byte, as printed by find -print0 - optional")
 	flag.StringVar(&searchText, "k", "", "Keyword to search")
-	flag.Int64Var(&maxSize, "s", 100, "Max file size to search in MB - optional")
-	flag.BoolVar(&json, "j", false, "Output in JSON - optional")
+	flag.BoolVar(&useRegex, "e", false, "Keyword is a regular expression (RE2 syntax) - optional")
+	flag.BoolVar(&useRegex, "regex", false, "Same as -e")
+	flag.StringVar(&matcher, "matcher", "", "How terms are matched: "+strings.Join(search.Matchers(), ", ")+"; literal by default - optional")
+	flag.IntVar(&distance, "distance", 0, "Edits allowed by -matcher fuzzy, 0 for one per four characters - optional")
+	flag.BoolVar(&ignoreCase, "i", false, "Case-insensitive match (Unicode case folding) - optional")
+	flag.BoolVar(&useQuery, "query", false, "Keyword is a boolean query, e.g. '(timeout OR deadline) AND NOT test' - optional")
+	flag.StringVar(&scope, "scope", search.ScopeFile, "Where query terms must occur together: file, paragraph or line - optional")
+	flag.IntVar(&afterLines, "A", 0, "Print N lines of context after each match - optional")
+	flag.IntVar(&beforeLines, "B", 0, "Print N lines of context before each match - optional")
+	flag.IntVar(&aroundLines, "C", 0, "Print N lines of context before and after each match - optional")
+	flag.Int64Var(&maxSize, "s", 0, "Max file size to search in MB, 0 for no limit - optional")
+	flag.BoolVar(&jsonLogs, "j", false, "Output logs in JSON - optional")
+	flag.BoolVar(&jsonLines, "jsonl", false, "Write results to stdout as JSON Lines events; logs go to stderr - optional")
 	flag.BoolVar(&verbose, "v", false, "Verbose = optional (prints all files searched)")
+	flag.Var(&includes, "include", "Only search files matching glob, e.g. '**/*.go'; repeatable, '!' negates - optional")
+	flag.Var(&excludes, "exclude", "Skip paths matching glob, e.g. '**/testdata/**'; repeatable - optional")
+	flag.StringVar(&typeNames, "type", "", "Only search files of these comma separated types, e.g. go,yaml,log - optional")
+	flag.Var(&typeAdds, "type-add", "Define or extend a file type, e.g. 'tf:*.tf,*.tfvars' or 'lua:*.lua,#!lua'; repeatable - optional")
+	flag.BoolVar(&typeList, "type-list", false, "Print the known file types")
+	flag.BoolVar(&follow, "L", false, "Follow symbolic links, searching each target once - optional")
+	flag.IntVar(&maxDepth, "maxdepth", 0, "Descend at most N levels below the path, 1 for its entries only; 0 for no limit - optional")
+	flag.IntVar(&minDepth, "mindepth", 0, "Search nothing less than N levels below the path - optional")
+	flag.BoolVar(&xdev, "xdev", false, "Do not descend into folders on other file systems, e.g. /proc or NFS mounts - optional")
+	flag.Int64Var(&charDevices, "char-devices", 0, "Search character devices, reading at most N bytes from each; 0 skips them - optional")
+	flag.BoolVar(&noExtract, "no-extract", false, "Search PDF and Office documents as raw bytes instead of extracting their text - optional")
+	flag.Int64Var(&maxDocSize, "max-doc-size", search.DefaultMaxDocumentSize>>20, "Largest document, or archive within an archive, read into memory to search it, in MB - optional")
+	flag.IntVar(&archDepth, "archive-depth", 2, "Search the members of zip, jar and tar archives, and of archives within them, down to N levels; 0 searches archives as files - optional")
+	flag.BoolVar(&noIgnore, "no-ignore", false, "Do not skip paths listed in .gitignore, .ignore and .gosearchignore files - optional")
+	flag.IntVar(&workers, "workers", runtime.NumCPU(), "Number of files searched at the same time - optional")
+	flag.BoolVar(&strict, "strict", false, "Abort on the first unreadable path instead of reporting it in the summary - optional")
+	flag.StringVar(&sortBy, "sort", "", "Print results in a stable order: path, size, mtime or matches, without timestamps - optional")
+	flag.DurationVar(&timeout, "timeout", 0, "Stop the search after this long, e.g. 30s or 5m, and print a partial summary; 0 for no limit - optional")
+	flag.BoolVar(&quiet, "q", false, "Quiet; print nothing, stop at the first match and report it in the exit status - optional")
 	flag.BoolVar(&help, "h", false, "Print help menu")
 }
 
@@ -64,12 +135,6 @@ func duration(start time.Time, name string) {
 	log.Printf("func %s elapsed %s\n", name, elapsed)
 }
 
-func errorCheck(err error) {
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
 func errorOut(message string) bool {
 	fmt.Fprintln(os.Stderr, message)
 	return false
@@ -85,153 +150,183 @@ func exists(path string) bool {
 	return true
 }
 
-// walkFiles walks all files and sub-directory paths
-func walkFiles(directory string, keyword string, filesFound chan walkresult, done chan bool) {
-
-	// launch goroutine to walk path; add wait count
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		err := filepath.Walk(directory, func(path string, f os.FileInfo, err error) error {
-			errorCheck(err)
-
-			// if file launch main search process
-			if !f.IsDir() {
-				fileCount()
-
-				// only launch search if file is under size limit,
-				if f.Size() < maxSize*1024*1024 {
-					wg.Add(1)
-					go readFile(path, f, filesFound)
-				} else {
-					log.WithFields(log.Fields{
-						"type": "file",
-						"name": f.Name(),
-						"path": path,
-					}).Warn("Skip file too large: ", f.Size())
-				}
-			}
-
-			// folder path, increment count
-			folderCount()
-			wg.Add(1)
-			go searchPath(path, f, filesFound)
-			return nil
-		})
+// printFileTypes lists the file types known to -type
+func printFileTypes(types map[string]search.FileType) {
+	var names []string
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t := types[name]
+		specs := append([]string(nil), t.Globs...)
+		for _, interp := range t.Interpreters {
+			specs = append(specs, "#!"+interp)
+		}
+		fmt.Printf("%s: %s\n", name, strings.Join(specs, ", "))
+	}
+}
 
-		// launch cleanup, but sync wait until goroutines complete
-		go cleanup(filesFound, done)
+// printErrors lists the errors met during the search, sorted by path
+func printErrors(errs []*search.PathError) {
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+	for _, e := range errs {
+		logError(e).Warn(e.Err)
+	}
+}
 
-		// check errors for walk func
-		errorCheck(err)
-		return
-	}()
-	return
+// logError adds the fields of a path error to a log entry
+func logError(e *search.PathError) *log.Entry {
+	return log.WithFields(log.Fields{
+		"path": e.Path,
+		"op":   e.Op,
+		"kind": e.Kind,
+	})
 }
 
-// readFile puts contents of file in memory, starts search
-func readFile(path string, f os.FileInfo, filesFound chan walkresult) {
-	defer wg.Done()
-	content, err := ioutil.ReadFile(path)
-	if err != nil {
-		if !verbose {
-			return
+// summary prints results, counts, lets user know search is done. A search
+// stopped early is reported as incomplete, with the reason and the last path
+// the walk reached.
+func summary(searchText string, path string, sum search.Summary, stopped string) {
+	if events != nil {
+		events.emitSummary(searchText, path, sum, stopped)
+		events.flush()
+	}
+	entry := log.WithFields(log.Fields{
+		"searchString":   searchText,         // text to search
+		"path":           path,               // file path requeted to search
+		"filesChecked":   sum.FilesChecked,   // num of files visited during search
+		"foldersChecked": sum.FoldersChecked, // num of folders visited during search
+		"filesFound":     sum.FilesFound,     // num of files that contain match for search string
+		"foldersFound":   sum.FoldersFound,   // num of folders that contain match for search string
+		"errors":         sum.Errors,         // num of paths that could not be walked or read
+		"errorsByType":   sum.ErrorsByType,   // num of errors by kind
+	})
+	if len(sum.Roots) > 1 {
+		for _, root := range sum.Roots {
+			log.WithFields(log.Fields{
+				"root":           root.Label,
+				"filesChecked":   root.FilesChecked,
+				"foldersChecked": root.FoldersChecked,
+				"filesFound":     root.FilesFound,
+				"foldersFound":   root.FoldersFound,
+				"errors":         root.Errors,
+			}).Info("Root completed")
 		}
-		log.WithFields(log.Fields{
-			"type": "file",
-			"name": f.Name(),
-			"path": path,
-		}).Warn("File cannot be read", f.Size())
+	}
+	if stopped != "" {
+		entry.WithFields(log.Fields{
+			"stopped":  stopped,      // why the search did not complete
+			"lastPath": sum.LastPath, // how far the walk got
+		}).Warn("Search incomplete")
 		return
 	}
-	wg.Add(1)
-	go searchFile(path, content, f, filesFound)
+	entry.Info("Search completed")
 }
 
-// searchFile parses the contents of file looking for keyword
-func searchFile(path string, content []byte, f os.FileInfo, filesFound chan walkresult) {
-	defer wg.Done()
-	x := string(content)
-	search := strings.Contains(x, searchText)
-	switch search {
-	case true:
-		lock.Lock()
-		numFound++
-		lock.Unlock()
-		found := true
-		filesFound <- walkresult{path, f.Name(), found, f.IsDir(), f.Size(), f.ModTime()}
-		return
-	case false:
-		found := false
-		filesFound <- walkresult{path, f.Name(), found, f.IsDir(), f.Size(), f.ModTime()}
-		return
+// rootPaths describes the paths searched, for the logs: the path of a single
+// root, or the labels of several
+func rootPaths(roots []search.Root) string {
+	if len(roots) == 1 {
+		return roots[0].Path
 	}
+	return strings.Join(rootLabels(roots), ",")
 }
 
-// searchPath searches match in file or folder name
-func searchPath(path string, f os.FileInfo, filesFound chan walkresult) {
-	defer wg.Done()
-	search := strings.Contains(f.Name(), searchText)
-	switch search {
-	case true:
-		if f.IsDir() {
-			lock.Lock()
-			dirFound++
-			lock.Unlock()
-		} else {
-			lock.Lock()
-			numFound++
-			lock.Unlock()
+// rootLabels returns the labels of the roots, each once, in order
+func rootLabels(roots []search.Root) []string {
+	var labels []string
+	seen := make(map[string]bool)
+	for _, root := range roots {
+		label := root.Label
+		if label == "" {
+			label = root.Path
+		}
+		if !seen[label] {
+			seen[label] = true
+			labels = append(labels, label)
 		}
-		found := true
-		filesFound <- walkresult{path, f.Name(), found, f.IsDir(), f.Size(), f.ModTime()}
-		return
-	case false:
-		found := false
-		filesFound <- walkresult{path, f.Name(), found, f.IsDir(), f.Size(), f.ModTime()}
-		return
 	}
+	return labels
 }
 
-// folderCount keeps count of folders visited during search
-func folderCount() {
-	lock.Lock()
-	folderVisit++
-	lock.Unlock()
+// stopOnSignal cancels the search on SIGINT or SIGTERM, and sends the signal
+// to caught. A second signal kills the process as usual.
+func stopOnSignal(cancel context.CancelFunc) <-chan os.Signal {
+	sigs := make(chan os.Signal, 1)
+	caught := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		signal.Stop(sigs)
+		caught <- sig
+		cancel()
+	}()
+	return caught
 }
 
-// fileCount keeps count of files visited during search
-func fileCount() {
-	lock.Lock()
-	fileVisit++
-	lock.Unlock()
+// fileType returns the type field logged for a result
+func fileType(r search.Result) string {
+	if r.IsDir {
+		return "folder"
+	}
+	return "file"
 }
 
-// waits for goroutines to complete, sets done signal and closes channels
-func cleanup(filesFound chan walkresult, done chan bool) {
-	wg.Wait()
-	close(filesFound)
-	done <- true
-	<-done
-	close(done)
-	return
+// printMatch prints a matching file or folder, one line per occurrence in
+// its contents, with the context lines surrounding each
+func printMatch(r search.Result) {
+	fields := log.Fields{
+		"type": fileType(r),
+		"name": r.Name,
+		"path": r.Path,
+	}
+	if r.NameMatch || len(r.Matches) == 0 {
+		log.WithFields(fields).Info("Match found")
+	}
+	for _, m := range r.Matches {
+		printContext(r, m.Before)
+		entry := log.WithFields(fields).WithFields(log.Fields{
+			"line":   m.Line,
+			"column": m.Column,
+			"offset": m.Offset,
+			"text":   m.Text,
+		})
+		if m.Location != "" {
+			entry = entry.WithField("location", m.Location)
+		}
+		entry.Info("Match found")
+		printContext(r, m.After)
+	}
 }
 
-// summary prints results, counts, lets user know search is done
-func summary(searchText string, path string) {
-	log.WithFields(log.Fields{
-		"searchString":   searchText,  // text to search
-		"path":           path,        // file path requeted to search
-		"filesChecked":   fileVisit,   // num of files visited during search
-		"foldersChecked": folderVisit, // num of folders visited during search
-		"filesFound":     numFound,    // num of files that contain match for search string
-		"foldersFound":   dirFound,    // num of folders that contain match for search string
-	}).Info("Search completed")
+// printContext prints the context lines surrounding a match
+func printContext(r search.Result, lines []search.ContextLine) {
+	for _, l := range lines {
+		log.WithFields(log.Fields{
+			"type": "file",
+			"name": r.Name,
+			"path": r.Path,
+			"line": l.Line,
+			"text": l.Text,
+		}).Info("Context")
+	}
 }
 
 func main() {
+	os.Exit(run())
+}
+
+// run searches as the flags ask and returns the exit code
+func run() int {
 	// main timer
-	defer duration(time.Now(), "main")
+	start := time.Now()
+	defer func() {
+		// sorted output is kept free of timings
+		if sortBy == "" {
+			duration(start, "main")
+		}
+	}()
 
 	// check args provided
 	flag.Parse()
@@ -239,91 +334,213 @@ func main() {
 
 	if help == true {
 		usage()
-		os.Exit(1)
+		return exitMatch
 	}
-	if inputDir == "" {
-		ok = errorOut("ERROR: Missing path to directory")
-	} else {
+	fileTypes := search.DefaultFileTypes()
+	for _, def := range typeAdds {
+		if err := search.AddFileType(fileTypes, def); err != nil {
+			errorOut("ERROR: " + err.Error())
+			return exitError
+		}
+	}
+	if typeList {
+		printFileTypes(fileTypes)
+		return exitMatch
+	}
+	var roots []search.Root
+	for _, arg := range append(inputDirs, flag.Args()...) {
 		// check path exists
-		verify := exists(inputDir)
-		if !verify {
-			ok = errorOut("ERROR: Path provided does not exist.")
+		root := parseRoot(arg)
+		if !exists(root.Path) {
+			ok = errorOut("ERROR: Path provided does not exist: " + root.Path)
 		}
+		roots = append(roots, root)
+	}
+	if filesFrom != "" {
+		listed, err := listedRoots(filesFrom, nullSep)
+		if err != nil {
+			ok = errorOut("ERROR: Cannot read paths to search: " + err.Error())
+		}
+		roots = append(roots, listed...)
+	} else if len(roots) == 0 {
+		ok = errorOut("ERROR: Missing path to directory")
+	}
+	if workers < 1 {
+		ok = errorOut("ERROR: Number of workers must be at least 1")
+	}
+	if aroundLines < 0 {
+		ok = errorOut("ERROR: Context line counts cannot be negative")
+	}
+	// -A and -B take precedence over -C, even when set to 0
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["A"] {
+		afterLines = aroundLines
+	}
+	if !set["B"] {
+		beforeLines = aroundLines
 	}
-	if searchText == "" {
-		ok = errorOut("ERROR: Missing keyword to search")
+
+	opts := search.Options{
+		Keyword:         searchText,
+		Regex:           useRegex,
+		Matcher:         matcher,
+		Distance:        distance,
+		IgnoreCase:      ignoreCase,
+		Query:           useQuery,
+		Scope:           scope,
+		Before:          beforeLines,
+		After:           afterLines,
+		MaxSize:         maxSize * 1024 * 1024,
+		Workers:         workers,
+		Follow:          follow,
+		MaxDepth:        maxDepth,
+		MinDepth:        minDepth,
+		SameDevice:      xdev,
+		CharDeviceBytes: charDevices,
+		NoExtract:       noExtract,
+		MaxDocumentSize: maxDocSize * 1024 * 1024,
+		ArchiveDepth:    archDepth,
+		NoIgnore:        noIgnore,
+		Include:         includes,
+		Exclude:         excludes,
+		FileTypes:       fileTypes,
+		Strict:          strict,
+		ReportAll:       verbose,
+		Sort:            sortBy,
+	}
+	if typeNames != "" {
+		opts.Types = strings.Split(typeNames, ",")
+	}
+	searcher, err := search.New(opts)
+	if qerr, isQuery := err.(*search.QueryError); isQuery {
+		errorOut("ERROR: Invalid query: " + qerr.Error())
+		errorOut("    " + searchText)
+		ok = errorOut("    " + strings.Repeat(" ", qerr.Column-1) + "^")
+	} else if err != nil {
+		msg := err.Error()
+		ok = errorOut("ERROR: " + strings.ToUpper(msg[:1]) + msg[1:])
 	}
 
 	if !ok {
 		usage()
-		os.Exit(1)
+		return exitError
 	}
 
 	// log set to JSON format
-	if json == true {
-		log.SetFormatter(&log.JSONFormatter{})
+	if jsonLogs == true {
+		log.SetFormatter(&log.JSONFormatter{DisableTimestamp: sortBy != ""})
 	} else {
 		// The TextFormatter is default, you don't actually have to do this.
-		log.SetFormatter(&log.TextFormatter{})
+		log.SetFormatter(&log.TextFormatter{DisableTimestamp: sortBy != ""})
+	}
+
+	// quiet mode only reports errors
+	if quiet {
+		log.SetLevel(log.ErrorLevel)
 	}
 
-	// create channels
-	filesFound := make(chan walkresult)
-	done := make(chan bool)
+	// results go to stdout as events, apart from the logs on stderr
+	log.SetOutput(os.Stderr)
+	if jsonLines && !quiet {
+		events = newEventWriter(sortBy != "")
+		events.emitBegin(start, searchText, roots)
+	}
 
 	// notify user search started
 	log.WithFields(log.Fields{
 		"searchString": searchText,
-		"path":         inputDir,
+		"path":         rootPaths(roots),
 	}).Info("Search started")
 
-	// start search work
-	go walkFiles(inputDir, searchText, filesFound, done)
-
-	// receive channel results and print
-loop:
-	for {
-		select {
-		case print := <-filesFound:
-			if (len(print.path) > 0) && verbose && (print.found == false) {
-				switch print.isDir {
-				case true:
-					log.WithFields(log.Fields{
-						"type": "folder",
-						"name": print.name,
-						"path": print.path,
-					}).Info("Match not found")
-				case false:
-					log.WithFields(log.Fields{
-						"type": "file",
-						"name": print.name,
-						"path": print.path,
-					}).Info("Match not found")
-				}
+	// start search work; a timeout or signal stops it early, and quiet
+	// mode cancels it at the first match
+	ctx, cancel := context.WithCancel(context.Background())
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+	caught := stopOnSignal(cancel)
+	results := searcher.SearchRoots(ctx, roots)
+
+	// receive results and print
+	var errs []*search.PathError
+	for r := range results.Results() {
+		switch r.Kind {
+		case search.KindMatch:
+			if quiet {
+				cancel()
 			}
-			if print.found == true {
-				switch print.isDir {
-				case true:
-					log.WithFields(log.Fields{
-						"type": "folder",
-						"name": print.name,
-						"path": print.path,
-					}).Info("Match found")
-				case false:
-					log.WithFields(log.Fields{
-						"type": "file",
-						"name": print.name,
-						"path": print.path,
-					}).Info("Match found")
-				}
-
+			if events != nil {
+				events.emitMatch(r)
+				continue
+			}
+			printMatch(r)
+		case search.KindNoMatch:
+			log.WithFields(log.Fields{
+				"type": fileType(r),
+				"name": r.Name,
+				"path": r.Path,
+			}).Info("Match not found")
+		case search.KindSkip:
+			if events != nil {
+				events.emitSkip(r)
+			}
+			if r.Reason == search.SkipTooLarge {
+				log.WithFields(log.Fields{
+					"type": "file",
+					"name": r.Name,
+					"path": r.Path,
+				}).Warn("Skip file too large: ", r.Size)
+			}
+		case search.KindError:
+			errs = append(errs, r.Err)
+			if events != nil {
+				events.emitError(r)
+			}
+			if verbose {
+				logError(r.Err).Warn(r.Err.Err)
 			}
-		case <-done:
-			done <- true
-			break loop
+		}
+	}
+	sum := results.Summary()
+	stopped := ""
+	select {
+	case sig := <-caught:
+		stopped = "interrupted by " + sig.String()
+	default:
+		if sum.Err == context.DeadlineExceeded {
+			stopped = "timeout after " + timeout.String()
 		}
 	}
 
-	// print search summary, file counts
-	summary(searchText, inputDir)
+	// strict mode aborts on the first error, without a summary
+	if perr, isPath := sum.Err.(*search.PathError); isPath && strict {
+		if events != nil {
+			events.flush()
+		}
+		logError(perr).Error(perr.Err)
+		return exitError
+	}
+
+	// list errors, unless verbose mode reported them as they happened,
+	// then print search summary, file counts
+	if !verbose {
+		printErrors(errs)
+	}
+	summary(searchText, rootPaths(roots), sum, stopped)
+
+	// a match wins over errors only in quiet mode, as in grep; an
+	// incomplete search is an error
+	matched := sum.FilesFound+sum.FoldersFound > 0
+	switch {
+	case matched && quiet:
+		return exitMatch
+	case sum.Errors > 0 || stopped != "":
+		return exitError
+	case matched:
+		return exitMatch
+	default:
+		return exitNoMatch
+	}
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/geriess/gosearch/search"
+)
+
+// parseRoot reads a path given on the command line, optionally labelled as
+// label=path. An argument naming an existing path is taken as a path even if
+// it holds an equals sign.
+func parseRoot(arg string) search.Root {
+	if i := strings.Index(arg, "="); i > 0 && !exists(arg) {
+		return search.Root{Label: arg[:i], Path: arg[i+1:]}
+	}
+	return search.Root{Path: arg}
+}
+
+// readPathList reads paths separated by newlines, or by NUL bytes if null
+// is set, leaving out empty entries
+func readPathList(r io.Reader, null bool) ([]string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	sep := []byte("\n")
+	if null {
+		sep = []byte{0}
+	}
+	var paths []string
+	for _, entry := range bytes.Split(data, sep) {
+		if !null {
+			entry = bytes.TrimSuffix(entry, []byte("\r"))
+		}
+		if len(entry) > 0 {
+			paths = append(paths, string(entry))
+		}
+	}
+	return paths, nil
+}
+
+// listedRoots returns the roots listed in a file, or on stdin for "-", all
+// labelled with the name of the list. Listed folders are not searched
+// recursively, as a list such as that of find names their contents too.
+func listedRoots(name string, null bool) ([]search.Root, error) {
+	var r io.Reader = os.Stdin
+	label := "stdin"
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+		label = name
+	}
+	paths, err := readPathList(r, null)
+	if err != nil {
+		return nil, err
+	}
+	roots := make([]search.Root, len(paths))
+	for i, p := range paths {
+		roots[i] = search.Root{Label: label, Path: p, Shallow: true}
+	}
+	return roots, nil
+}
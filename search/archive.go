@@ -0,0 +1,474 @@
+package search
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// Limits on the bytes inflated from an archive found in the tree, those of
+// the archives within it included, so that a zip bomb is refused before it
+// takes up a worker or its memory: an archive may inflate to
+// maxArchiveRatio times its size, to at least minArchiveBytes and at most
+// maxArchiveBytes
+const (
+	maxArchiveRatio = 100
+	minArchiveBytes = 16 << 20
+	maxArchiveBytes = 1 << 30
+)
+
+// archiveFormats are the archives whose members are searched, by lower case
+// name suffix
+var archiveFormats = []struct{ suffix, format string }{
+	{".tar.gz", "TGZ"},
+	{".tgz", "TGZ"},
+	{".tar", "TAR"},
+	{".zip", "ZIP"},
+	{".jar", "ZIP"},
+	{".war", "ZIP"},
+	{".ear", "ZIP"},
+}
+
+// errArchiveStopped stops the search of an archive whose inflate budget ran
+// out, once the error has been reported
+var errArchiveStopped = errors.New("archive search stopped")
+
+// archiveFormat returns the format of an archive by name, or "" for another
+// file
+func archiveFormat(name string) string {
+	name = strings.ToLower(name)
+	for _, a := range archiveFormats {
+		if strings.HasSuffix(name, a.suffix) {
+			return a.format
+		}
+	}
+	return ""
+}
+
+// isArchive tells whether the members of a file in the tree are searched
+func (w *walker) isArchive(p string, info os.FileInfo) bool {
+	return w.s.opts.ArchiveDepth > 0 && info.Mode().IsRegular() && archiveFormat(p) != ""
+}
+
+// archive is an archive whose members are searched, found in the tree or
+// within another archive
+type archive struct {
+	root   int    // index into Summary.Roots
+	path   string // the path in the tree, then "!/" and the path of each archive within
+	format string // ZIP, TAR or TGZ
+	level  int    // 1 for an archive in the tree, 2 for one within it, and so on
+	size   int64
+	data   io.ReaderAt                   // the archive, for ZIP
+	open   func() (io.ReadCloser, error) // reads the archive from its start, for TAR and TGZ
+	budget *inflateBudget
+	limit  int64 // largest member read into memory, Options.MaxDocumentSize
+}
+
+// member is a file or folder within an archive
+type member struct {
+	name string // path within the archive, as stored
+	info os.FileInfo
+	open func() (io.ReadCloser, error)
+}
+
+// inflateBudget counts down the bytes that may still be inflated from an
+// archive in the tree
+type inflateBudget struct {
+	left int64 // negative once the budget has run out
+	err  error // returned once it has
+}
+
+func newInflateBudget(format string, size int64) *inflateBudget {
+	limit := maxArchiveRatio * size
+	if limit < minArchiveBytes {
+		limit = minArchiveBytes
+	}
+	if limit > maxArchiveBytes {
+		limit = maxArchiveBytes
+	}
+	return &inflateBudget{
+		left: limit,
+		err:  docError(format, "inflates to more than %d MiB, the limit for an archive of %d bytes", limit>>20, size),
+	}
+}
+
+// budgetReader reads inflated bytes, charging them to a budget
+type budgetReader struct {
+	r      io.Reader
+	budget *inflateBudget
+}
+
+func (r *budgetReader) Read(p []byte) (int, error) {
+	if r.budget.left < 0 {
+		return 0, r.budget.err
+	}
+	if int64(len(p)) > r.budget.left+1 {
+		p = p[:r.budget.left+1]
+	}
+	n, err := r.r.Read(p)
+	r.budget.left -= int64(n)
+	if r.budget.left < 0 {
+		return 0, r.budget.err
+	}
+	return n, err
+}
+
+// readCloser reads from one reader and closes another
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// gunzip returns a function opening the gzip compressed stream opened by
+// open, charging what it inflates to a budget
+func gunzip(open func() (io.ReadCloser, error), budget *inflateBudget) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		rc, err := open()
+		if err != nil {
+			return nil, err
+		}
+		gz, err := gzip.NewReader(rc)
+		if err != nil {
+			rc.Close()
+			return nil, docError("TGZ", "%v", err)
+		}
+		return readCloser{&budgetReader{gz, budget}, rc}, nil
+	}
+}
+
+// members calls fn for each member of an archive, in the order they are
+// stored
+func (a *archive) members(fn func(m member) error) error {
+	if a.format == "ZIP" {
+		zr, err := openZip(a.data, a.size, "ZIP")
+		if err != nil {
+			return err
+		}
+		for _, f := range zr.File {
+			f := f
+			open := func() (io.ReadCloser, error) {
+				// refuse a bomb before inflating it, when it says its size
+				if f.UncompressedSize64 > uint64(a.budget.left) {
+					a.budget.left = -1
+					return nil, a.budget.err
+				}
+				rc, err := f.Open()
+				if err != nil {
+					return nil, docError("ZIP", "%s: %v", f.Name, err)
+				}
+				return readCloser{&budgetReader{rc, a.budget}, rc}, nil
+			}
+			if err := fn(member{f.Name, f.FileInfo(), open}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	rc, err := a.open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+	for i := 0; ; i++ {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return a.tarError(err)
+		}
+		// the member is read as the archive goes, then read again from
+		// the start of the archive if need be
+		i, current := i, true
+		open := func() (io.ReadCloser, error) {
+			if current {
+				current = false
+				return ioutil.NopCloser(tr), nil
+			}
+			return a.reopen(i)
+		}
+		if err := fn(member{h.Name, h.FileInfo(), open}); err != nil {
+			return err
+		}
+	}
+}
+
+// reopen reads a TAR archive again, from its start, up to its member i
+func (a *archive) reopen(i int) (io.ReadCloser, error) {
+	rc, err := a.open()
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(rc)
+	for j := 0; j <= i; j++ {
+		if _, err := tr.Next(); err != nil {
+			rc.Close()
+			return nil, a.tarError(err)
+		}
+	}
+	return readCloser{tr, rc}, nil
+}
+
+// tarError returns an error met reading a TAR archive as a format error
+func (a *archive) tarError(err error) error {
+	if _, ok := err.(*formatError); ok {
+		return err
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return docError(a.format, "%v", err)
+}
+
+// nested returns the archive held by a member. A ZIP archive is read into
+// memory, as its members are found from its end.
+func (a *archive) nested(m member, p, format string) (*archive, error) {
+	inner := &archive{
+		root:   a.root,
+		path:   p,
+		format: format,
+		level:  a.level + 1,
+		size:   m.info.Size(),
+		open:   m.open,
+		budget: a.budget,
+		limit:  a.limit,
+	}
+	switch format {
+	case "ZIP":
+		data, err := a.read(m)
+		if err != nil {
+			return nil, err
+		}
+		inner.data, inner.size = bytes.NewReader(data), int64(len(data))
+	case "TGZ":
+		inner.open = gunzip(m.open, a.budget)
+	}
+	return inner, nil
+}
+
+// read reads a member into memory
+func (a *archive) read(m member) ([]byte, error) {
+	if m.info.Size() > a.limit {
+		return nil, docError(a.format, "%s larger than %d MiB", m.name, a.limit>>20)
+	}
+	rc, err := m.open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// memberName cleans the path of a member into a slash separated path
+// relative to the archive, such as "etc/app.conf"
+func memberName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.Replace(name, "\\", "/", -1)), "/")
+}
+
+// searchArchive searches the members of an archive in the tree, and those
+// of the archives within it down to Options.ArchiveDepth levels, and
+// returns their results
+func (w *walker) searchArchive(j job, buf []byte) ([]Result, *PathError) {
+	file, err := os.Open(j.path)
+	if err != nil {
+		return nil, &PathError{Op: "open", Err: err}
+	}
+	defer file.Close()
+	size := j.f.Size()
+	a := &archive{
+		root:   j.root,
+		path:   j.path,
+		format: archiveFormat(j.path),
+		level:  1,
+		size:   size,
+		data:   file,
+		open: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(io.NewSectionReader(file, 0, size)), nil
+		},
+		limit: w.s.opts.MaxDocumentSize,
+	}
+	a.budget = newInflateBudget(a.format, size)
+	if a.format == "TGZ" {
+		a.open = gunzip(a.open, a.budget)
+	}
+	return w.searchMembers(a, buf)
+}
+
+// searchMembers searches the members of an archive. Their results are sent
+// as they come, so that those found before the search is stopped are
+// reported, or returned to be sent in order with Options.Sort. A member
+// whose inflate budget runs out reports it, and stops the search of the
+// archive.
+func (w *walker) searchMembers(a *archive, buf []byte) ([]Result, *PathError) {
+	var results []Result
+	err := a.members(func(m member) error {
+		if w.ctx.Err() != nil {
+			return w.ctx.Err()
+		}
+		for _, res := range w.searchMember(a, m, buf) {
+			if w.order != nil {
+				results = append(results, res)
+				continue
+			}
+			w.send(res)
+		}
+		if a.budget.left < 0 {
+			return errArchiveStopped
+		}
+		return nil
+	})
+	switch {
+	case err == nil || err == errArchiveStopped:
+		return results, nil
+	case w.ctx.Err() != nil:
+		return results, &PathError{Op: "read", Err: err}
+	}
+	return results, memberError(a.format, err)
+}
+
+// searchMember searches a member of an archive like a file in the tree:
+// the filters and size limit apply to it, its name and contents are
+// matched, the text of a document is extracted, and the members of an
+// archive within are searched in turn
+func (w *walker) searchMember(a *archive, m member, buf []byte) []Result {
+	name := memberName(m.name)
+	if name == "" || m.info.IsDir() {
+		return nil
+	}
+	p := a.path + "!/" + name
+	format := ""
+	if a.level < w.s.opts.ArchiveDepth {
+		format = archiveFormat(name)
+	}
+	// members are not opened to read a "#!" line
+	skip, membersOnly := w.filtered(p, name, false, format != "")
+	if skip {
+		return []Result{w.skipped(a.root, p, m.info, SkipFiltered)}
+	}
+	if reason := specialFile(m.info, 0); reason != "" {
+		return []Result{w.skipped(a.root, p, m.info, reason)}
+	}
+	w.count(a.root, func(c *Counts) { c.FilesChecked++ })
+	if w.s.opts.MaxSize > 0 && m.info.Size() > w.s.opts.MaxSize {
+		return []Result{w.skipped(a.root, p, m.info, SkipTooLarge)}
+	}
+	return w.searchEntry(a.root, p, path.Base(name), m.info, membersOnly, func() (bool, []Match, []Result, *PathError) {
+		if format != "" {
+			inner, err := a.nested(m, p, format)
+			if err != nil {
+				return false, nil, nil, memberError(a.format, err)
+			}
+			members, perr := w.searchMembers(inner, buf)
+			return false, nil, members, perr
+		}
+		found, matches, perr := w.searchMemberContents(a, m, name, buf)
+		return found, matches, nil, perr
+	})
+}
+
+// searchMemberContents scans the contents of a member. A query needing two
+// passes reads the member again, from a copy kept in memory if it is no
+// larger than Options.MaxDocumentSize, rather than inflating the archive
+// up to it anew. A member named like a document is read into memory, and
+// its text is extracted if it starts like one.
+func (w *walker) searchMemberContents(a *archive, m member, name string, buf []byte) (bool, []Match, *PathError) {
+	if format, ok := documentFormat(name); ok && !w.s.opts.NoExtract {
+		data, err := a.read(m)
+		if err != nil {
+			return false, nil, memberError(a.format, err)
+		}
+		if format.holds(bytes.NewReader(data)) {
+			return w.searchDocument(bytes.NewReader(data), int64(len(data)), format.extract, buf)
+		}
+		rewind := func() (io.Reader, error) {
+			return bytes.NewReader(data), nil
+		}
+		text, _ := rewind()
+		found, matches, err := scanContent(w.ctx, text, rewind, buf, w.s.query, w.s.opts.Before, w.s.opts.After)
+		if err != nil {
+			return false, nil, &PathError{Op: "read", Err: err}
+		}
+		return found, matches, nil
+	}
+	rc, err := m.open()
+	if err != nil {
+		return false, nil, memberError(a.format, err)
+	}
+	defer func() {
+		if rc != nil {
+			rc.Close()
+		}
+	}()
+	var r io.Reader = rc
+	var kept *memberCopy
+	if w.s.query.twoPass() && m.info.Size() <= a.limit {
+		kept = &memberCopy{whole: true, limit: a.limit}
+		r = io.TeeReader(rc, kept)
+	}
+	rewind := func() (io.Reader, error) {
+		if kept != nil && kept.whole {
+			return bytes.NewReader(kept.data), nil
+		}
+		rc.Close()
+		rc, err = m.open()
+		return rc, err
+	}
+	found, matches, err := scanContent(w.ctx, r, rewind, buf, w.s.query, w.s.opts.Before, w.s.opts.After)
+	if err != nil {
+		return false, nil, memberError(a.format, err)
+	}
+	return found, matches, nil
+}
+
+// memberCopy keeps the bytes read from a member, as long as they are no more
+// than limit
+type memberCopy struct {
+	data  []byte
+	whole bool // false once the member turned out larger
+	limit int64
+}
+
+func (c *memberCopy) Write(p []byte) (int, error) {
+	if c.whole && int64(len(c.data)+len(p)) <= c.limit {
+		c.data = append(c.data, p...)
+	} else {
+		c.data, c.whole = nil, false
+	}
+	return len(p), nil
+}
+
+// memberError returns the PathError of an archive or member that could
+// not be read: op "archive" if the archive is malformed, truncated or
+// inflates too much, "read" otherwise
+func memberError(format string, err error) *PathError {
+	if _, ok := err.(*formatError); ok {
+		return &PathError{Op: "archive", Err: err}
+	}
+	if malformed(err) {
+		return &PathError{Op: "archive", Err: docError(format, "%v", err)}
+	}
+	return &PathError{Op: "read", Err: err}
+}
+
+// malformed tells whether an error met reading an archive means that it is
+// malformed or truncated
+func malformed(err error) bool {
+	if _, ok := err.(flate.CorruptInputError); ok {
+		return true
+	}
+	switch err {
+	case io.ErrUnexpectedEOF, zip.ErrFormat, zip.ErrChecksum, zip.ErrAlgorithm, gzip.ErrHeader, gzip.ErrChecksum, tar.ErrHeader:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+package search
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testFile is a member of a test archive
+type testFile struct {
+	name, data string
+}
+
+func testTar(t *testing.T, files ...testFile) string {
+	t.Helper()
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(f.data))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func testTGZ(t *testing.T, files ...testFile) string {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write([]byte(testTar(t, files...)))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func testZip(t *testing.T, files ...testFile) string {
+	t.Helper()
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(f.data))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// searchTree searches a tree built from files and renders its results as
+// "path: lines" for matches, "path: reason" for skips and "path: op: error"
+// for errors, with paths relative to the tree
+func searchTree(t *testing.T, opts Options, files map[string]string) []string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "gosearch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, files)
+
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := s.Search(context.Background(), dir)
+	var got []string
+	for res := range run.Results() {
+		if res.IsDir {
+			continue
+		}
+		p := strings.TrimPrefix(res.Path, dir+string(os.PathSeparator))
+		switch res.Kind {
+		case KindMatch:
+			var lines []string
+			for _, m := range res.Matches {
+				lines = append(lines, fmt.Sprint(m.Line))
+			}
+			got = append(got, p+": "+strings.Join(lines, ","))
+		case KindSkip:
+			got = append(got, p+": "+res.Reason)
+		case KindError:
+			got = append(got, p+": "+res.Err.Op+": "+res.Err.Err.Error())
+		}
+	}
+	run.Summary()
+	return got
+}
+
+func TestInflateBudget(t *testing.T) {
+	tests := []struct {
+		size int64
+		want int64
+	}{
+		{0, minArchiveBytes},
+		{1 << 10, minArchiveBytes},
+		{1 << 20, 100 << 20},
+		{100 << 20, maxArchiveBytes},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.size), func(t *testing.T) {
+			if got := newInflateBudget("ZIP", tt.size).left; got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArchiveBudget(t *testing.T) {
+	// compressed to a few KiB, inflated beyond the 16 MiB an archive that
+	// small may inflate to
+	bomb := strings.Repeat("\x00", minArchiveBytes+1)
+	tests := []struct {
+		name    string
+		archive string
+		file    string
+		want    []string
+	}{
+		{
+			name:    "zip member",
+			file:    "bomb.zip",
+			archive: testZip(t, testFile{"a.txt", "needle"}, testFile{"zeros", bomb}, testFile{"b.txt", "needle"}),
+			want: []string{
+				"bomb.zip!/a.txt: 1",
+				"bomb.zip!/zeros: archive: ZIP: inflates to more than 16 MiB, the limit for an archive of %d bytes",
+			},
+		},
+		{
+			name:    "tar.gz member",
+			file:    "bomb.tgz",
+			archive: testTGZ(t, testFile{"a.txt", "needle"}, testFile{"zeros", bomb}, testFile{"b.txt", "needle"}),
+			want: []string{
+				"bomb.tgz!/a.txt: 1",
+				"bomb.tgz!/zeros: archive: TGZ: inflates to more than 16 MiB, the limit for an archive of %d bytes",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := searchTree(t, Options{Keyword: "needle", ArchiveDepth: 1}, map[string]string{tt.file: tt.archive})
+			want := append([]string(nil), tt.want...)
+			want[1] = fmt.Sprintf(want[1], len(tt.archive))
+			if strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestArchiveDepth(t *testing.T) {
+	// compressible, so that the note is not stored as it is
+	inner := testZip(t, testFile{"note.txt", "needle\n" + strings.Repeat("filler\n", 500) + "needle\n"})
+	outer := testTar(t, testFile{"readme.txt", "needle"}, testFile{"inner.zip", inner})
+	tests := []struct {
+		depth int
+		want  []string
+	}{
+		{0, []string{"outer.tar: 1"}},
+		{1, []string{"outer.tar!/readme.txt: 1"}},
+		{2, []string{"outer.tar!/readme.txt: 1", "outer.tar!/inner.zip!/note.txt: 1,502"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.depth), func(t *testing.T) {
+			got := searchTree(t, Options{Keyword: "needle", ArchiveDepth: tt.depth}, map[string]string{"outer.tar": outer})
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestArchiveTwoPass checks a query that needs two passes over a member,
+// read again from a copy in memory or, for a member larger than
+// Options.MaxDocumentSize, from the start of the archive
+func TestArchiveTwoPass(t *testing.T) {
+	members := []testFile{
+		{"first.txt", "alpha\n"},
+		{"both.txt", "alpha\nnothing\nbeta\n"},
+		{"last.txt", "beta\n"},
+	}
+	tests := []struct {
+		name    string
+		file    string
+		archive string
+		limit   int64
+	}{
+		{"tar, copied", "a.tar", testTar(t, members...), 0},
+		{"tar, read again", "a.tar", testTar(t, members...), 8},
+		{"tar.gz, copied", "a.tgz", testTGZ(t, members...), 0},
+		{"tar.gz, read again", "a.tgz", testTGZ(t, members...), 8},
+		{"zip, read again", "a.zip", testZip(t, members...), 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{Keyword: "alpha AND beta", Query: true, ArchiveDepth: 1, MaxDocumentSize: tt.limit}
+			got := searchTree(t, opts, map[string]string{tt.file: tt.archive})
+			if want := tt.file + "!/both.txt: 1,3"; len(got) != 1 || got[0] != want {
+				t.Errorf("got %q, want [%q]", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package search
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// Special sector numbers of a compound file
+const (
+	cfbMaxSector = 0xfffffffa
+	cfbNoStream  = 0xffffffff
+)
+
+var cfbSignature = []byte{0xd0, 0xcf, 0x11, 0xe0, 0xa1, 0xb1, 0x1a, 0xe1}
+
+// cfbFile reads the streams of an OLE2 compound file, the container of
+// legacy Office documents. Streams are chains of sectors listed in the file
+// allocation table (FAT); streams smaller than the cutoff are chains of mini
+// sectors, kept in the mini stream and listed in the mini FAT.
+type cfbFile struct {
+	r          io.ReaderAt
+	size       int64
+	format     string // document format, for errors
+	sectorSize int
+	miniSize   int
+	cutoff     uint64
+	fat        []uint32
+	miniFAT    []uint32
+	dir        []cfbEntry
+	mini       []byte // the mini stream
+}
+
+// cfbEntry is an entry of the directory of a compound file
+type cfbEntry struct {
+	name               string
+	typ                byte // 1 storage, 2 stream, 5 root
+	left, right, child uint32
+	start              uint32
+	size               uint64
+}
+
+// openCFB reads the header, allocation tables and directory of a compound
+// file
+func openCFB(r io.ReaderAt, size int64, format string) (*cfbFile, error) {
+	header := make([]byte, 512)
+	if _, err := r.ReadAt(header, 0); err != nil || !bytes.Equal(header[:8], cfbSignature) {
+		return nil, docError(format, "not an OLE2 compound file")
+	}
+	le := binary.LittleEndian
+	c := &cfbFile{r: r, size: size, format: format}
+	shift, miniShift := le.Uint16(header[0x1e:]), le.Uint16(header[0x20:])
+	if shift != 9 && shift != 12 || miniShift != 6 {
+		return nil, docError(format, "bad sector size")
+	}
+	c.sectorSize, c.miniSize = 1<<shift, 1<<miniShift
+	c.cutoff = uint64(le.Uint32(header[0x38:]))
+
+	// the FAT sectors are listed in the header, then in a chain of DIFAT
+	// sectors, each ending with the next one
+	var fatSectors []uint32
+	for i := 0; i < 109; i++ {
+		fatSectors = append(fatSectors, le.Uint32(header[0x4c+4*i:]))
+	}
+	next := le.Uint32(header[0x44:])
+	for i := 0; next <= cfbMaxSector && i < c.sectors(); i++ {
+		sector, err := c.sector(next)
+		if err != nil {
+			return nil, err
+		}
+		n := c.sectorSize/4 - 1
+		for j := 0; j < n; j++ {
+			fatSectors = append(fatSectors, le.Uint32(sector[4*j:]))
+		}
+		next = le.Uint32(sector[4*n:])
+	}
+	fatCount := int(le.Uint32(header[0x2c:]))
+	for _, s := range fatSectors {
+		if s > cfbMaxSector || len(c.fat) >= fatCount*c.sectorSize/4 {
+			break
+		}
+		sector, err := c.sector(s)
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < c.sectorSize; j += 4 {
+			c.fat = append(c.fat, le.Uint32(sector[j:]))
+		}
+	}
+
+	miniFAT, err := c.chain(le.Uint32(header[0x3c:]), -1)
+	if err != nil {
+		return nil, err
+	}
+	for j := 0; j+4 <= len(miniFAT); j += 4 {
+		c.miniFAT = append(c.miniFAT, le.Uint32(miniFAT[j:]))
+	}
+
+	dir, err := c.chain(le.Uint32(header[0x30:]), -1)
+	if err != nil {
+		return nil, err
+	}
+	for j := 0; j+128 <= len(dir); j += 128 {
+		e := dir[j : j+128]
+		n := int(le.Uint16(e[64:]))
+		if n > 64 {
+			n = 64
+		}
+		units := make([]uint16, 0, n/2)
+		for k := 0; k+1 < n; k += 2 {
+			if u := le.Uint16(e[k:]); u != 0 {
+				units = append(units, u)
+			}
+		}
+		size := le.Uint64(e[120:])
+		if shift == 9 {
+			size &= 0xffffffff
+		}
+		c.dir = append(c.dir, cfbEntry{
+			name:  string(utf16.Decode(units)),
+			typ:   e[66],
+			left:  le.Uint32(e[68:]),
+			right: le.Uint32(e[72:]),
+			child: le.Uint32(e[76:]),
+			start: le.Uint32(e[116:]),
+			size:  size,
+		})
+	}
+	if len(c.dir) == 0 || c.dir[0].typ != 5 {
+		return nil, docError(format, "no root entry")
+	}
+	root := c.dir[0]
+	if c.mini, err = c.chain(root.start, int64(root.size)); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// sectors returns the number of sectors the file can hold, which bounds
+// every chain
+func (c *cfbFile) sectors() int {
+	return int(c.size / int64(c.sectorSize))
+}
+
+// sector reads a sector
+func (c *cfbFile) sector(n uint32) ([]byte, error) {
+	buf := make([]byte, c.sectorSize)
+	off := (int64(n) + 1) * int64(c.sectorSize)
+	if _, err := c.r.ReadAt(buf, off); err != nil && !(err == io.EOF && off < c.size) {
+		return nil, docError(c.format, "sector %d out of the file", n)
+	}
+	return buf, nil
+}
+
+// chain reads a chain of sectors starting at start, up to size bytes if
+// size is not -1
+func (c *cfbFile) chain(start uint32, size int64) ([]byte, error) {
+	var data []byte
+	for n, i := start, 0; n <= cfbMaxSector; i++ {
+		if size >= 0 && int64(len(data)) >= size {
+			break
+		}
+		if int(n) >= len(c.fat) || i > c.sectors() {
+			return nil, docError(c.format, "broken sector chain")
+		}
+		sector, err := c.sector(n)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, sector...)
+		n = c.fat[n]
+	}
+	if size >= 0 {
+		if int64(len(data)) < size {
+			return nil, docError(c.format, "stream shorter than its size")
+		}
+		data = data[:size]
+	}
+	return data, nil
+}
+
+// miniChain reads a chain of mini sectors from the mini stream
+func (c *cfbFile) miniChain(start uint32, size int64) ([]byte, error) {
+	var data []byte
+	for n, i := start, 0; n <= cfbMaxSector && int64(len(data)) < size; i++ {
+		off := int(n) * c.miniSize
+		if int(n) >= len(c.miniFAT) || i > len(c.miniFAT) || off+c.miniSize > len(c.mini) {
+			return nil, docError(c.format, "broken mini sector chain")
+		}
+		data = append(data, c.mini[off:off+c.miniSize]...)
+		n = c.miniFAT[n]
+	}
+	if int64(len(data)) < size {
+		return nil, docError(c.format, "stream shorter than its size")
+	}
+	return data[:size], nil
+}
+
+// stream reads a stream of the root storage by name, ignoring case, or
+// returns nil if there is none
+func (c *cfbFile) stream(name string) ([]byte, error) {
+	// the entries of a storage form a tree, under its child
+	seen := make(map[uint32]bool)
+	stack := []uint32{c.dir[0].child}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if i == cfbNoStream || int(i) >= len(c.dir) || seen[i] {
+			continue
+		}
+		seen[i] = true
+		e := c.dir[i]
+		stack = append(stack, e.left, e.right)
+		if e.typ != 2 || !strings.EqualFold(e.name, name) {
+			continue
+		}
+		if e.size > maxPartSize || int64(e.size) > c.size {
+			return nil, docError(c.format, "%s stream larger than the file", name)
+		}
+		if e.size < c.cutoff {
+			return c.miniChain(e.start, int64(e.size))
+		}
+		return c.chain(e.start, int64(e.size))
+	}
+	return nil, nil
+}
@@ -0,0 +1,247 @@
+package search
+
+import (
+	"encoding/binary"
+	"io"
+	"unicode/utf16"
+)
+
+// Word stories, in the order their text is stored after the main text
+var wordStories = []string{"body", "footnotes", "headers", "comments", "endnotes", "text boxes", "header text boxes"}
+
+// wordStoryCounts are the indexes of the character counts of the stories
+// among the 32-bit fields of the FIB
+var wordStoryCounts = []int{3, 4, 5, 7, 8, 9, 10}
+
+// extractDOC writes the text of a Word 97-2003 document: each story, such
+// as the body or the footnotes, in a section of its own. The text is put
+// together from the pieces listed in the piece table, each stored as UTF-16
+// or as 8-bit text; field codes are left out, and their results kept.
+func extractDOC(r io.ReaderAt, size int64, doc *document) error {
+	// its streams are read into memory whole
+	if err := doc.fits(size, "DOC"); err != nil {
+		return err
+	}
+	c, err := openCFB(r, size, "DOC")
+	if err != nil {
+		return err
+	}
+	word, err := c.stream("WordDocument")
+	if err != nil {
+		return err
+	}
+	le := binary.LittleEndian
+	if len(word) < 0x22 || le.Uint16(word) != 0xa5ec {
+		return docError("DOC", "no Word document stream")
+	}
+	flags := le.Uint16(word[0x0a:])
+	if flags&0x0100 != 0 {
+		return docError("DOC", "encrypted")
+	}
+
+	// Word 6 and 95 files hold their text as one 8-bit run
+	nFib := le.Uint16(word[2:])
+	if nFib < 101 {
+		fcMin, fcMac := le.Uint32(word[0x18:]), le.Uint32(word[0x1c:])
+		if fcMin > fcMac || int(fcMac) > len(word) {
+			return docError("DOC", "bad text bounds")
+		}
+		w := &wordText{doc: doc}
+		doc.begin(wordStories[0])
+		w.write8(word[fcMin:fcMac])
+		return nil
+	}
+
+	// the FIB: fixed fields, then counts of 16-bit, 32-bit and 64-bit fields
+	pos := 0x20
+	read16 := func() int {
+		if pos+2 > len(word) {
+			return 0
+		}
+		pos += 2
+		return int(le.Uint16(word[pos-2:]))
+	}
+	pos += 2 * read16()
+	cslw := read16()
+	lw := pos
+	pos += 4 * cslw
+	cbRgFcLcb := read16()
+	fcLcb := pos
+	if cslw < 11 || cbRgFcLcb < 34 || fcLcb+34*8 > len(word) {
+		return docError("DOC", "short file information block")
+	}
+	var counts []int
+	for _, i := range wordStoryCounts {
+		counts = append(counts, int(int32(le.Uint32(word[lw+4*i:]))))
+	}
+	fcClx, lcbClx := le.Uint32(word[fcLcb+33*8:]), le.Uint32(word[fcLcb+33*8+4:])
+
+	tableName := "0Table"
+	if flags&0x0200 != 0 {
+		tableName = "1Table"
+	}
+	table, err := c.stream(tableName)
+	if err != nil {
+		return err
+	}
+	if uint64(fcClx)+uint64(lcbClx) > uint64(len(table)) {
+		return docError("DOC", "piece table out of the %s stream", tableName)
+	}
+	pieces, err := parseClx(table[fcClx : fcClx+lcbClx])
+	if err != nil {
+		return err
+	}
+
+	// stories follow each other in character positions
+	w := &wordText{doc: doc}
+	cp := 0
+	for i, n := range counts {
+		if n <= 0 {
+			continue
+		}
+		doc.begin(wordStories[i])
+		for _, p := range pieces {
+			start, end := maxInt(p.start, cp), minInt(p.end, cp+n)
+			if start >= end {
+				continue
+			}
+			if p.compressed {
+				off := p.fc + (start - p.start)
+				if off < 0 || off+(end-start) > len(word) {
+					return docError("DOC", "text piece out of the document")
+				}
+				w.write8(word[off : off+end-start])
+			} else {
+				off := p.fc + 2*(start-p.start)
+				if off < 0 || off+2*(end-start) > len(word) {
+					return docError("DOC", "text piece out of the document")
+				}
+				w.write16(word[off : off+2*(end-start)])
+			}
+		}
+		cp += n
+	}
+	return nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// wordPiece is a run of characters stored together: characters start to
+// end, at byte offset fc of the WordDocument stream
+type wordPiece struct {
+	start, end int
+	fc         int
+	compressed bool // 8-bit text rather than UTF-16
+}
+
+// parseClx reads the piece table from the Clx of a Word document, skipping
+// the property modifiers before it
+func parseClx(clx []byte) ([]wordPiece, error) {
+	le := binary.LittleEndian
+	for len(clx) > 0 && clx[0] == 0x01 {
+		if len(clx) < 3 {
+			break
+		}
+		n := int(le.Uint16(clx[1:]))
+		if 3+n > len(clx) {
+			break
+		}
+		clx = clx[3+n:]
+	}
+	if len(clx) < 5 || clx[0] != 0x02 {
+		return nil, docError("DOC", "no piece table")
+	}
+	plc := clx[5:]
+	if n := int(le.Uint32(clx[1:])); n <= len(plc) {
+		plc = plc[:n]
+	}
+	// n+1 character positions, then n piece descriptors of 8 bytes
+	n := (len(plc) - 4) / 12
+	if n <= 0 {
+		return nil, docError("DOC", "empty piece table")
+	}
+	pieces := make([]wordPiece, n)
+	for i := range pieces {
+		fc := le.Uint32(plc[4*(n+1)+8*i+2:])
+		p := wordPiece{start: int(le.Uint32(plc[4*i:])), end: int(le.Uint32(plc[4*i+4:]))}
+		if fc&0x40000000 != 0 {
+			p.fc, p.compressed = int(fc&0x3fffffff)/2, true
+		} else {
+			p.fc = int(fc)
+		}
+		pieces[i] = p
+	}
+	return pieces, nil
+}
+
+// wordText writes Word characters as text: paragraph marks and breaks end
+// lines, table cell marks become tabs, and field codes, from a field begin
+// mark to its separator, are left out
+type wordText struct {
+	doc   *document
+	field []bool // open fields, true while in their codes
+}
+
+func (w *wordText) write8(text []byte) {
+	for _, b := range text {
+		r := rune(b)
+		if b >= 0x80 && b < 0xa0 {
+			r = winAnsiEncoding[b]
+		}
+		w.char(r)
+	}
+}
+
+func (w *wordText) write16(text []byte) {
+	units := make([]uint16, len(text)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(text[2*i:])
+	}
+	for _, r := range utf16.Decode(units) {
+		w.char(r)
+	}
+}
+
+func (w *wordText) char(r rune) {
+	switch r {
+	case 0x13: // field begin
+		w.field = append(w.field, true)
+		return
+	case 0x14: // field separator, the result follows
+		if len(w.field) > 0 {
+			w.field[len(w.field)-1] = false
+		}
+		return
+	case 0x15: // field end
+		if len(w.field) > 0 {
+			w.field = w.field[:len(w.field)-1]
+		}
+		return
+	}
+	for _, code := range w.field {
+		if code {
+			return
+		}
+	}
+	switch r {
+	case '\r', 0x0b, 0x0c: // paragraph mark, line break, page or section break
+		w.doc.WriteString("\n")
+	case 0x07: // cell or row mark
+		w.doc.WriteString("\t")
+	case 0x1e: // non-breaking hyphen
+		w.doc.WriteString("-")
+	case 0xa0:
+		w.doc.WriteString(" ")
+	case '\t':
+		w.doc.WriteString("\t")
+	default:
+		if r >= 0x20 && r != 0xfffd {
+			w.doc.WriteString(string(r))
+		}
+	}
+}
@@ -0,0 +1,493 @@
+package search
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// testStream is a stream of the root storage of a compound file
+type testStream struct {
+	name string
+	data []byte
+}
+
+// testCFB builds an OLE2 compound file of 512-byte sectors holding streams
+// in its root storage. Streams under 4096 bytes go to the mini stream.
+func testCFB(streams ...testStream) []byte {
+	const sectorSize, miniSize, cutoff = 512, 64, 4096
+	const endOfChain, fatSector, free = 0xfffffffe, 0xfffffffd, 0xffffffff
+	le := binary.LittleEndian
+	var sectors [][]byte
+	var fat []uint32
+	alloc := func(data []byte) uint32 {
+		start := len(sectors)
+		n := (len(data) + sectorSize - 1) / sectorSize
+		for i := 0; i < n; i++ {
+			sector := make([]byte, sectorSize)
+			copy(sector, data[i*sectorSize:])
+			sectors = append(sectors, sector)
+			fat = append(fat, uint32(start+i+1))
+		}
+		fat[len(fat)-1] = endOfChain
+		return uint32(start)
+	}
+
+	type entry struct {
+		name  string
+		typ   byte
+		child uint32
+		start uint32
+		size  int
+	}
+	entries := []entry{{name: "Root Entry", typ: 5, child: free, start: endOfChain}}
+	var mini []byte
+	var miniFAT []uint32
+	for i, s := range streams {
+		e := entry{name: s.name, typ: 2, child: free, start: endOfChain, size: len(s.data)}
+		switch {
+		case len(s.data) >= cutoff:
+			e.start = alloc(s.data)
+		case len(s.data) > 0:
+			e.start = uint32(len(mini) / miniSize)
+			n := (len(s.data) + miniSize - 1) / miniSize
+			for j := 0; j < n; j++ {
+				miniFAT = append(miniFAT, e.start+uint32(j)+1)
+			}
+			miniFAT[len(miniFAT)-1] = endOfChain
+			mini = append(mini, s.data...)
+			mini = append(mini, make([]byte, n*miniSize-len(s.data))...)
+		}
+		if i == 0 {
+			entries[0].child = 1
+		}
+		entries = append(entries, e)
+	}
+	if len(mini) > 0 {
+		entries[0].start, entries[0].size = alloc(mini), len(mini)
+	}
+	miniFATStart := uint32(endOfChain)
+	if len(miniFAT) > 0 {
+		data := make([]byte, 4*len(miniFAT))
+		for i, next := range miniFAT {
+			le.PutUint32(data[4*i:], next)
+		}
+		miniFATStart = alloc(data)
+	}
+
+	// the directory, each stream the right sibling of the one before
+	dir := make([]byte, 128*len(entries))
+	for i, e := range entries {
+		d := dir[128*i:]
+		name := utf16.Encode([]rune(e.name))
+		for j, u := range name {
+			le.PutUint16(d[2*j:], u)
+		}
+		le.PutUint16(d[64:], uint16(2*len(name)+2))
+		d[66], d[67] = e.typ, 1
+		right := uint32(free)
+		if i > 0 && i+1 < len(entries) {
+			right = uint32(i + 1)
+		}
+		le.PutUint32(d[68:], free)
+		le.PutUint32(d[72:], right)
+		le.PutUint32(d[76:], e.child)
+		le.PutUint32(d[116:], e.start)
+		le.PutUint64(d[120:], uint64(e.size))
+	}
+	dirStart := alloc(dir)
+
+	perSector := sectorSize / 4
+	fatCount := 1
+	for len(sectors)+fatCount > fatCount*perSector {
+		fatCount++
+	}
+	fatStart := len(sectors)
+	for i := 0; i < fatCount; i++ {
+		fat = append(fat, fatSector)
+	}
+	for len(fat) < fatCount*perSector {
+		fat = append(fat, free)
+	}
+	for i := 0; i < fatCount; i++ {
+		sector := make([]byte, sectorSize)
+		for j := 0; j < perSector; j++ {
+			le.PutUint32(sector[4*j:], fat[i*perSector+j])
+		}
+		sectors = append(sectors, sector)
+	}
+
+	header := make([]byte, sectorSize)
+	copy(header, cfbSignature)
+	le.PutUint16(header[0x18:], 0x3e)
+	le.PutUint16(header[0x1a:], 3)
+	le.PutUint16(header[0x1c:], 0xfffe)
+	le.PutUint16(header[0x1e:], 9)
+	le.PutUint16(header[0x20:], 6)
+	le.PutUint32(header[0x2c:], uint32(fatCount))
+	le.PutUint32(header[0x30:], dirStart)
+	le.PutUint32(header[0x38:], cutoff)
+	le.PutUint32(header[0x3c:], miniFATStart)
+	le.PutUint32(header[0x40:], uint32((len(miniFAT)*4+sectorSize-1)/sectorSize))
+	le.PutUint32(header[0x44:], endOfChain)
+	for i := 0; i < 109; i++ {
+		difat := uint32(free)
+		if i < fatCount {
+			difat = uint32(fatStart + i)
+		}
+		le.PutUint32(header[0x4c+4*i:], difat)
+	}
+	return bytes.Join(append([][]byte{header}, sectors...), nil)
+}
+
+// testPiece is a piece of the text of a Word document, stored as 8-bit
+// text or as UTF-16
+type testPiece struct {
+	text       string
+	compressed bool
+}
+
+// testDOC builds a Word 97-2003 document whose stories have the given
+// character counts, and whose text is stored in pieces
+func testDOC(flags uint16, counts []int, pieces ...testPiece) []byte {
+	le := binary.LittleEndian
+	const textStart = 0x400
+
+	// the FIB: 14 16-bit fields, 22 32-bit fields, then 93 pairs of offsets
+	// and lengths, of which the 34th locates the Clx in the table stream
+	fib := make([]byte, 0x20+2+28+2+22*4+2+93*8)
+	le.PutUint16(fib[0:], 0xa5ec)
+	le.PutUint16(fib[2:], 0xc1)
+	le.PutUint16(fib[0x0a:], flags|0x0200)
+	pos := 0x20
+	le.PutUint16(fib[pos:], 14)
+	pos += 2 + 28
+	le.PutUint16(fib[pos:], 22)
+	for i, n := range counts {
+		le.PutUint32(fib[pos+2+4*wordStoryCounts[i]:], uint32(n))
+	}
+	pos += 2 + 22*4
+	le.PutUint16(fib[pos:], 93)
+	fcLcb := pos + 2
+
+	word := make([]byte, textStart, 8192)
+	copy(word, fib)
+	var cps, pcds []byte
+	cp := 0
+	for _, p := range pieces {
+		cps = append(cps, le32(uint32(cp))...)
+		pcd := make([]byte, 8)
+		if p.compressed {
+			le.PutUint32(pcd[2:], uint32(2*len(word))|0x40000000)
+			word = append(word, p.text...)
+			cp += len(p.text)
+		} else {
+			if len(word)%2 != 0 {
+				word = append(word, 0)
+			}
+			le.PutUint32(pcd[2:], uint32(len(word)))
+			units := utf16.Encode([]rune(p.text))
+			for _, u := range units {
+				word = append(word, le16(u)...)
+			}
+			cp += len(units)
+		}
+		pcds = append(pcds, pcd...)
+	}
+	cps = append(cps, le32(uint32(cp))...)
+	plc := append(cps, pcds...)
+
+	// a property modifier comes before the piece table
+	clx := []byte{0x01, 3, 0, 'a', 'b', 'c', 0x02}
+	clx = append(clx, le32(uint32(len(plc)))...)
+	clx = append(clx, plc...)
+	table := append(make([]byte, 16), clx...)
+	le.PutUint32(word[fcLcb+33*8:], 16)
+	le.PutUint32(word[fcLcb+33*8+4:], uint32(len(clx)))
+
+	// a stream over the cutoff, as Word writes it
+	word = append(word, make([]byte, 5000-len(word))...)
+	return testCFB(testStream{"WordDocument", word}, testStream{"1Table", table})
+}
+
+func TestParseClx(t *testing.T) {
+	plc := func(cps []uint32, fcs []uint32) []byte {
+		var b []byte
+		for _, cp := range cps {
+			b = append(b, le32(cp)...)
+		}
+		for _, fc := range fcs {
+			b = append(b, 0, 0)
+			b = append(b, le32(fc)...)
+			b = append(b, 0, 0)
+		}
+		return b
+	}
+	pcdt := func(plc []byte) []byte {
+		return append(append([]byte{0x02}, le32(uint32(len(plc)))...), plc...)
+	}
+	tests := []struct {
+		name string
+		clx  []byte
+		want []wordPiece
+		err  string
+	}{
+		{
+			name: "one UTF-16 piece",
+			clx:  pcdt(plc([]uint32{0, 10}, []uint32{0x800})),
+			want: []wordPiece{{0, 10, 0x800, false}},
+		},
+		{
+			name: "8-bit and UTF-16 pieces",
+			clx:  pcdt(plc([]uint32{0, 4, 9}, []uint32{0x40000000 | 0x1000, 0x900})),
+			want: []wordPiece{{0, 4, 0x800, true}, {4, 9, 0x900, false}},
+		},
+		{
+			name: "after property modifiers",
+			clx:  append([]byte{0x01, 2, 0, 'x', 'y', 0x01, 0, 0}, pcdt(plc([]uint32{0, 3}, []uint32{0x40000000 | 0x200}))...),
+			want: []wordPiece{{0, 3, 0x100, true}},
+		},
+		{
+			name: "length beyond the data",
+			clx:  append(append([]byte{0x02}, le32(1000)...), plc([]uint32{0, 3}, []uint32{0x600})...),
+			want: []wordPiece{{0, 3, 0x600, false}},
+		},
+		{
+			name: "no piece table",
+			clx:  []byte{0x01, 1, 0, 'x'},
+			err:  "DOC: no piece table",
+		},
+		{
+			name: "property modifier overrunning the Clx",
+			clx:  []byte{0x01, 50, 0, 0x02, 0, 0, 0, 0},
+			err:  "DOC: no piece table",
+		},
+		{
+			name: "empty piece table",
+			clx:  pcdt(le32(0)),
+			err:  "DOC: empty piece table",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseClx(tt.clx)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseClx: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("piece %d: got %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExtractDOC(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+		want []string
+		err  string
+	}{
+		{
+			name: "stories across pieces",
+			file: testDOC(0, []int{32, 13},
+				testPiece{"Quarterly memo\rThe budget", true},
+				testPiece{" is ok\rA footnote ☃\r", false}),
+			want: []string{"body: Quarterly memo", "body: The budget is ok", "footnotes: A footnote ☃"},
+		},
+		{
+			name: "field codes left out",
+			file: testDOC(0, []int{47},
+				testPiece{"See \x13 HYPERLINK \"http://x\" \x14the plan\x15 now\r", true},
+				testPiece{"a\x07b\x07\r", false}),
+			want: []string{"body: See the plan now", "body: a\tb\t"},
+		},
+		{
+			name: "8-bit text in the Windows code page",
+			file: testDOC(0, []int{6}, testPiece{"caf\xe9 \x93", true}),
+			want: []string{"body: café “"},
+		},
+		{
+			name: "empty stories skipped",
+			file: testDOC(0, []int{5, 0, 7}, testPiece{"Body\rHeader\r", false}),
+			want: []string{"body: Body", "headers: Header"},
+		},
+		{
+			name: "encrypted",
+			file: testDOC(0x0100, []int{5}, testPiece{"Body\r", true}),
+			err:  "DOC: encrypted",
+		},
+		{
+			name: "counts beyond the pieces",
+			file: testDOC(0, []int{5000}, testPiece{"Body\r", true}),
+			want: []string{"body: Body"},
+		},
+		{
+			name: "no Word stream",
+			file: testCFB(testStream{"Workbook", []byte("not a document")}),
+			err:  "DOC: no Word document stream",
+		},
+		{
+			name: "not a compound file",
+			file: []byte(strings.Repeat("{\\rtf1 plain text}", 40)),
+			err:  "DOC: not an OLE2 compound file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := &document{limit: DefaultMaxDocumentSize}
+			err := extract(extractDOC, bytes.NewReader(tt.file), int64(len(tt.file)), doc)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extractDOC: %v", err)
+			}
+			if got := docLines(doc); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// biffRecord returns a BIFF record
+func biffRecord(typ uint16, data ...[]byte) []byte {
+	body := bytes.Join(data, nil)
+	rec := le16(typ)
+	rec = append(rec, le16(uint16(len(body)))...)
+	return append(rec, body...)
+}
+
+// biffCell returns the row, column and format of a cell
+func biffCell(row, col uint16) []byte {
+	return bytes.Join([][]byte{le16(row), le16(col), le16(0)}, nil)
+}
+
+func TestExtractXLS(t *testing.T) {
+	bof := func(typ uint16) []byte {
+		return biffRecord(biffBOF, le16(0x0600), le16(typ), make([]byte, 12))
+	}
+	u32 := le32
+	f64 := func(v float64) []byte { return le64(math.Float64bits(v)) }
+
+	// the shared strings, the second split across a CONTINUE record that
+	// switches it from 8-bit text to UTF-16
+	sst := biffRecord(biffSST, u32(3), u32(2), []byte{7, 0, 0}, []byte("Revenue"), []byte{11, 0, 0}, []byte("Q2 "))
+	sst = append(sst, biffRecord(biffContinue, []byte{1}, utf16le("widgets☃"))...)
+	sheet := func(name string, offset uint32) []byte {
+		return biffRecord(biffBoundSheet, u32(offset), []byte{0, 0, byte(len(name)), 0}, []byte(name))
+	}
+
+	cells := bytes.Join([][]byte{
+		bof(0x0010),
+		biffRecord(biffLabelSST, biffCell(0, 0), u32(0)),
+		biffRecord(biffNumber, biffCell(0, 1), f64(1234.5)),
+		biffRecord(biffRK, biffCell(1, 0), u32(42<<2|2)),
+		biffRecord(biffRK, biffCell(1, 1), u32(12345<<2|3)),
+		biffRecord(biffLabelSST, biffCell(13, 27), u32(1)),
+		biffRecord(biffBoolErr, biffCell(2, 0), []byte{1, 0}),
+		biffRecord(biffBoolErr, biffCell(2, 1), []byte{0x07, 1}),
+		biffRecord(biffFormula, biffCell(3, 0), []byte{0, 0, 0, 0, 0, 0, 0xff, 0xff}, make([]byte, 6)),
+		biffRecord(biffString, []byte{14, 0, 0}, []byte("formula result")),
+		biffRecord(biffEOF),
+	}, nil)
+	build := func(name string, cells []byte) []byte {
+		size := len(bof(0x0005)) + len(sheet(name, 0)) + len(sst) + 4
+		globals := bytes.Join([][]byte{bof(0x0005), sheet(name, uint32(size)), sst, biffRecord(biffEOF)}, nil)
+		return append(globals, cells...)
+	}
+
+	tests := []struct {
+		name string
+		file []byte
+		want []string
+		err  string
+	}{
+		{
+			name: "cells",
+			file: testCFB(testStream{"Workbook", build("Summary", cells)}),
+			want: []string{
+				"Summary!A1: Revenue", "Summary!B1: 1234.5", "Summary!A2: 42", "Summary!B2: 123.45",
+				"Summary!AB14: Q2 widgets☃", "Summary!A3: TRUE", "Summary!B3: #DIV/0!", "Summary!A4: formula result",
+			},
+		},
+		{
+			name: "quoted sheet name",
+			file: testCFB(testStream{"Workbook", build("Q2 Data", append(bof(0x0010), biffRecord(biffLabelSST, biffCell(0, 0), u32(0))...))}),
+			want: []string{"'Q2 Data'!A1: Revenue"},
+		},
+		{
+			name: "encrypted",
+			file: testCFB(testStream{"Workbook", append(bof(0x0005), biffRecord(biffFilePass, make([]byte, 6))...)}),
+			err:  "XLS: encrypted",
+		},
+		{
+			name: "no workbook",
+			file: testCFB(testStream{"Workbook", []byte("<html><table></table></html>")}),
+			err:  "XLS: no BIFF5 or BIFF8 workbook stream",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := &document{limit: DefaultMaxDocumentSize}
+			err := extract(extractXLS, bytes.NewReader(tt.file), int64(len(tt.file)), doc)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extractXLS: %v", err)
+			}
+			if got := docLines(doc); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// utf16le returns text as UTF-16LE
+func utf16le(text string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(text)) {
+		b = append(b, le16(u)...)
+	}
+	return b
+}
+
+// le16, le32 and le64 return little-endian integers
+func le16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, v)
+	return b
+}
+
+func le32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+func le64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return b
+}
@@ -0,0 +1,72 @@
+package search
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// PathError is an error met on one path while walking or reading it. The
+// search records it and carries on, unless Options.Strict is set.
+type PathError struct {
+	Path string
+	Op   string // walk, open, read, extract or archive
+	Kind string // class of error, see errorKind
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	err := e.Err
+	if perr, ok := err.(*os.PathError); ok {
+		err = perr.Err
+	}
+	return e.Op + " " + e.Path + ": " + err.Error()
+}
+
+// formatError is a document whose text cannot be extracted, because it is
+// malformed or uses a feature not supported
+type formatError struct {
+	format string // PDF, DOCX, ...
+	msg    string
+}
+
+func (e *formatError) Error() string {
+	return e.format + ": " + e.msg
+}
+
+// docError returns a formatError for a document of the given format
+func docError(doc, format string, args ...interface{}) error {
+	return &formatError{doc, fmt.Sprintf(format, args...)}
+}
+
+// errorKind classifies an error for the summary counts: permission,
+// notExist, loop, nameTooLong, io, tooManyOpenFiles, format or other
+func errorKind(err error) string {
+	if _, ok := err.(*formatError); ok {
+		return "format"
+	}
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	}
+	switch {
+	case os.IsPermission(err):
+		return "permission"
+	case os.IsNotExist(err):
+		return "notExist"
+	case err == syscall.ELOOP:
+		return "loop"
+	case err == syscall.ENAMETOOLONG:
+		return "nameTooLong"
+	case err == syscall.EIO:
+		return "io"
+	case err == syscall.EMFILE || err == syscall.ENFILE:
+		return "tooManyOpenFiles"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,194 @@
+package search
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxDocumentText bounds the text kept from one document, so that a small
+// compressed file cannot take up unbounded memory
+const maxDocumentText = 64 << 20
+
+// maxDocumentSections bounds the sections of one document, which may each
+// hold as little as one character, such as the cells of a sheet
+const maxDocumentSections = 1 << 20
+
+// extractor writes the text of a document to doc
+type extractor func(r io.ReaderAt, size int64, doc *document) error
+
+// documents are the formats whose text is extracted, by lower case file
+// name extension
+var documents = map[string]docFormat{
+	".pdf":  {isPDF, extractPDF},
+	".doc":  {isCFB, extractDOC},
+	".dot":  {isCFB, extractDOC},
+	".docx": {isZip, extractDOCX},
+	".docm": {isZip, extractDOCX},
+	".dotx": {isZip, extractDOCX},
+	".dotm": {isZip, extractDOCX},
+	".xls":  {isCFB, extractXLS},
+	".xlt":  {isCFB, extractXLS},
+	".xlsx": {isZip, extractXLSX},
+	".xlsm": {isZip, extractXLSX},
+	".xltx": {isZip, extractXLSX},
+	".xltm": {isZip, extractXLSX},
+	".pptx": {isZip, extractPPTX},
+	".pptm": {isZip, extractPPTX},
+	".potx": {isZip, extractPPTX},
+	".potm": {isZip, extractPPTX},
+	".ppsx": {isZip, extractPPTX},
+	".ppsm": {isZip, extractPPTX},
+	".odt":  {isZip, extractODF},
+	".ott":  {isZip, extractODF},
+	".ods":  {isZip, extractODF},
+	".ots":  {isZip, extractODF},
+	".odp":  {isZip, extractODF},
+	".otp":  {isZip, extractODF},
+}
+
+// docFormat is a format of document: sniff tells whether a file starts
+// like one, from its first bytes, and extract writes its text
+type docFormat struct {
+	sniff   func(head []byte) bool
+	extract extractor
+}
+
+// documentFormat returns the format of a document by file name, if its
+// text is extracted
+func documentFormat(name string) (docFormat, bool) {
+	f, ok := documents[strings.ToLower(filepath.Ext(name))]
+	return f, ok
+}
+
+// holds tells whether a file starts like a document of the format. One
+// that does not, such as an HTML or RTF file saved with a .xls or .doc
+// name, is searched as it is rather than reported as malformed.
+func (f docFormat) holds(r io.ReaderAt) bool {
+	head := make([]byte, 1024)
+	n, _ := r.ReadAt(head, 0)
+	return f.sniff(head[:n])
+}
+
+// isPDF tells whether a file starts like a PDF document, whose header may
+// follow some junk
+func isPDF(head []byte) bool {
+	return bytes.Contains(head, []byte("%PDF-"))
+}
+
+// isCFB tells whether a file starts like an OLE2 compound file
+func isCFB(head []byte) bool {
+	return bytes.HasPrefix(head, cfbSignature)
+}
+
+// isZip tells whether a file starts like a zip container
+func isZip(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("PK\x03\x04")) || bytes.HasPrefix(head, []byte("PK\x05\x06"))
+}
+
+// section is a part of a document, such as a page, starting on a line of
+// its text
+type section struct {
+	line     int
+	location string
+}
+
+// document is the text extracted from a file. Its sections start on new
+// lines, and each match is located in the section holding its line.
+type document struct {
+	text     []byte
+	lines    int // newlines in text
+	sections []section
+	full     bool  // text reached maxDocumentText, or sections maxDocumentSections, and the rest was dropped
+	limit    int64 // largest file read into memory, Options.MaxDocumentSize
+}
+
+// fits returns an error if a file is too large to be read into memory to
+// extract its text
+func (d *document) fits(size int64, format string) error {
+	if size > d.limit {
+		return docError(format, "larger than %d MiB", d.limit>>20)
+	}
+	return nil
+}
+
+// begin starts a new section, on a new line
+func (d *document) begin(location string) {
+	if d.full {
+		return
+	}
+	if len(d.sections) == maxDocumentSections {
+		d.full = true
+		return
+	}
+	d.endLine()
+	d.sections = append(d.sections, section{d.lines + 1, location})
+}
+
+// endLine ends the current line, unless it is empty
+func (d *document) endLine() {
+	if len(d.text) > 0 && d.text[len(d.text)-1] != '\n' {
+		d.WriteString("\n")
+	}
+}
+
+// WriteString adds text to the current section
+func (d *document) WriteString(s string) (int, error) {
+	if d.full {
+		return len(s), nil
+	}
+	if room := maxDocumentText - len(d.text); len(s) > room {
+		s = s[:room]
+		d.full = true
+	}
+	d.text = append(d.text, s...)
+	d.lines += strings.Count(s, "\n")
+	return len(s), nil
+}
+
+// locate returns the location of the section holding a 1-based line
+func (d *document) locate(line int) string {
+	i := sort.Search(len(d.sections), func(i int) bool { return d.sections[i].line > line })
+	if i == 0 {
+		return ""
+	}
+	return d.sections[i-1].location
+}
+
+// extract runs an extractor over a file. Extractors check what they read
+// against the bounds of the data; a panic on malformed input they still
+// missed is turned into an error as a last resort.
+func extract(fn extractor, r io.ReaderAt, size int64, doc *document) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &formatError{"document", fmt.Sprint("malformed: ", r)}
+		}
+	}()
+	return fn(r, size, doc)
+}
+
+// searchDocument searches the text extracted from a file, or from a
+// member of an archive read into memory. Matches are located in the
+// sections of the document; their lines and offsets count within the
+// extracted text.
+func (w *walker) searchDocument(r io.ReaderAt, size int64, fn extractor, buf []byte) (bool, []Match, *PathError) {
+	doc := &document{limit: w.s.opts.MaxDocumentSize}
+	if err := extract(fn, r, size, doc); err != nil {
+		return false, nil, &PathError{Op: "extract", Err: err}
+	}
+	rewind := func() (io.Reader, error) {
+		return bytes.NewReader(doc.text), nil
+	}
+	text, _ := rewind()
+	found, matches, err := scanContent(w.ctx, text, rewind, buf, w.s.query, w.s.opts.Before, w.s.opts.After)
+	if err != nil {
+		return false, nil, &PathError{Op: "read", Err: err}
+	}
+	for i := range matches {
+		matches[i].Location = doc.locate(matches[i].Line)
+	}
+	return found, matches, nil
+}
@@ -0,0 +1,21 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package search
+
+import "os"
+
+// fileID identifies a file or folder by device and inode
+type fileID struct {
+	dev, ino uint64
+}
+
+// idOf is not supported here, so loops through links are not detected
+func idOf(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
+
+// deviceOf is not supported here, so searches may cross file systems
+func deviceOf(path string) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,222 @@
+package search
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FileType is a named set of files, matched by name glob or by the
+// interpreter of a "#!" line
+type FileType struct {
+	Globs        []string
+	Interpreters []string
+}
+
+// builtinTypes holds the built-in file types
+var builtinTypes = map[string]FileType{
+	"c":        {Globs: []string{"*.c", "*.h"}},
+	"conf":     {Globs: []string{"*.conf", "*.cfg", "*.ini", "*.properties"}},
+	"cpp":      {Globs: []string{"*.cc", "*.cpp", "*.cxx", "*.hh", "*.hpp", "*.hxx"}},
+	"css":      {Globs: []string{"*.css", "*.scss", "*.less"}},
+	"csv":      {Globs: []string{"*.csv", "*.tsv"}},
+	"doc":      {Globs: []string{"*.doc", "*.docx", "*.docm", "*.odt", "*.pdf", "*.rtf"}},
+	"go":       {Globs: []string{"*.go"}},
+	"html":     {Globs: []string{"*.html", "*.htm", "*.xhtml"}},
+	"java":     {Globs: []string{"*.java"}},
+	"js":       {Globs: []string{"*.js", "*.cjs", "*.mjs", "*.jsx"}, Interpreters: []string{"node"}},
+	"json":     {Globs: []string{"*.json"}},
+	"log":      {Globs: []string{"*.log", "*.log.[0-9]*"}},
+	"make":     {Globs: []string{"Makefile", "makefile", "GNUmakefile", "*.mk"}},
+	"markdown": {Globs: []string{"*.md", "*.markdown"}},
+	"perl":     {Globs: []string{"*.pl", "*.pm"}, Interpreters: []string{"perl"}},
+	"php":      {Globs: []string{"*.php"}, Interpreters: []string{"php"}},
+	"py":       {Globs: []string{"*.py", "*.pyi"}, Interpreters: []string{"python"}},
+	"ruby":     {Globs: []string{"*.rb", "Gemfile", "Rakefile"}, Interpreters: []string{"ruby"}},
+	"rust":     {Globs: []string{"*.rs"}},
+	"sh":       {Globs: []string{"*.sh", "*.bash", "*.zsh"}, Interpreters: []string{"sh", "bash", "zsh", "ksh", "dash"}},
+	"sheet":    {Globs: []string{"*.xls", "*.xlsx", "*.xlsm", "*.ods"}},
+	"slides":   {Globs: []string{"*.ppt", "*.pptx", "*.pptm", "*.odp"}},
+	"sql":      {Globs: []string{"*.sql"}},
+	"toml":     {Globs: []string{"*.toml"}},
+	"ts":       {Globs: []string{"*.ts", "*.tsx"}},
+	"txt":      {Globs: []string{"*.txt"}},
+	"xml":      {Globs: []string{"*.xml", "*.xsd", "*.xsl"}},
+	"yaml":     {Globs: []string{"*.yaml", "*.yml"}},
+}
+
+// DefaultFileTypes returns a copy of the built-in file types, which
+// AddFileType can extend before they are set in Options.FileTypes
+func DefaultFileTypes() map[string]FileType {
+	types := make(map[string]FileType, len(builtinTypes))
+	for name, t := range builtinTypes {
+		types[name] = FileType{
+			Globs:        append([]string(nil), t.Globs...),
+			Interpreters: append([]string(nil), t.Interpreters...),
+		}
+	}
+	return types
+}
+
+// AddFileType defines or extends a file type from a name:spec[,spec]
+// definition, where each spec is a name glob such as *.tf or an interpreter
+// such as #!lua
+func AddFileType(types map[string]FileType, def string) error {
+	i := strings.Index(def, ":")
+	if i <= 0 || i == len(def)-1 {
+		return fmt.Errorf("type definition %q must look like name:*.ext,#!interpreter", def)
+	}
+	name := def[:i]
+	t := types[name]
+	for _, spec := range strings.Split(def[i+1:], ",") {
+		switch {
+		case strings.HasPrefix(spec, "#!"):
+			t.Interpreters = append(t.Interpreters, spec[2:])
+		case spec == "":
+		default:
+			if err := checkGlob(spec); err != nil {
+				return err
+			}
+			t.Globs = append(t.Globs, spec)
+		}
+	}
+	types[name] = t
+	return nil
+}
+
+// checkGlob reports a malformed glob pattern
+func checkGlob(pattern string) error {
+	for _, segment := range strings.Split(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchPattern matches a slash-separated path relative to the search root.
+// A pattern without a "/" is matched against the name alone.
+func matchPattern(pattern, rel string) bool {
+	if !strings.Contains(pattern, "/") {
+		return matchGlob(pattern, path.Base(rel))
+	}
+	return matchGlob(pattern, rel)
+}
+
+// pathFilter selects the files to search from -include, -exclude and -type
+type pathFilter struct {
+	includes []string
+	excludes []string
+	types    []FileType
+}
+
+// newPathFilter checks the filter flags. Include patterns starting with "!"
+// are exclude patterns.
+func newPathFilter(includes, excludes []string, typeNames []string, types map[string]FileType) (*pathFilter, error) {
+	pf := &pathFilter{}
+	for _, pattern := range includes {
+		if strings.HasPrefix(pattern, "!") {
+			pf.excludes = append(pf.excludes, pattern[1:])
+		} else {
+			pf.includes = append(pf.includes, pattern)
+		}
+	}
+	pf.excludes = append(pf.excludes, excludes...)
+	for _, pattern := range append(pf.includes, pf.excludes...) {
+		if err := checkGlob(pattern); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range typeNames {
+		t, ok := types[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown file type %q", name)
+		}
+		pf.types = append(pf.types, t)
+	}
+	return pf, nil
+}
+
+// skipDir reports whether a folder, relative to the search root, is excluded
+func (pf *pathFilter) skipDir(rel string) bool {
+	for _, pattern := range pf.excludes {
+		if matchPattern(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipFile reports whether a file is filtered out. Only regular files
+// without an extension are opened, to read a "#!" line when a type asks for
+// one.
+func (pf *pathFilter) skipFile(p, rel string, regular bool) bool {
+	if pf.skipDir(rel) {
+		return true
+	}
+	if len(pf.includes) > 0 {
+		included := false
+		for _, pattern := range pf.includes {
+			if matchPattern(pattern, rel) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return true
+		}
+	}
+	if len(pf.types) == 0 {
+		return false
+	}
+	for _, t := range pf.types {
+		for _, pattern := range t.Globs {
+			if matchPattern(pattern, rel) {
+				return false
+			}
+		}
+	}
+	if filepath.Ext(p) != "" || !regular {
+		return true
+	}
+	interp := interpreter(p)
+	for _, t := range pf.types {
+		for _, want := range t.Interpreters {
+			if interp == want || (strings.HasPrefix(interp, want) && strings.Trim(interp[len(want):], "0123456789.") == "") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// interpreter returns the program named by a file's "#!" line, looking
+// through /usr/bin/env
+func interpreter(p string) string {
+	file, err := os.Open(p)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	line, _ := bufio.NewReaderSize(file, 128).ReadSlice('\n')
+	if !strings.HasPrefix(string(line), "#!") {
+		return ""
+	}
+	fields := strings.Fields(string(line[2:]))
+	if len(fields) == 0 {
+		return ""
+	}
+	prog := path.Base(fields[0])
+	if prog == "env" {
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "-") {
+				return path.Base(field)
+			}
+		}
+		return ""
+	}
+	return prog
+}
@@ -0,0 +1,158 @@
+package search
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// fullFolds holds the multi-rune ("F") mappings of Unicode full case folding.
+// Every other rune folds through its simple case folding orbit. Turkic
+// specific ("T") mappings are not applied: İ folds to i followed by U+0307.
+var fullFolds = map[rune]string{
+	0x00DF: "ss",
+	0x0130: "i\u0307",
+	0x0149: "\u02bcn",
+	0x01F0: "j\u030c",
+	0x0390: "\u03b9\u0308\u0301",
+	0x03B0: "\u03c5\u0308\u0301",
+	0x0587: "\u0565\u0582",
+	0x1E96: "h\u0331",
+	0x1E97: "t\u0308",
+	0x1E98: "w\u030a",
+	0x1E99: "y\u030a",
+	0x1E9A: "a\u02be",
+	0x1E9E: "ss",
+	0x1F50: "\u03c5\u0313",
+	0x1F52: "\u03c5\u0313\u0300",
+	0x1F54: "\u03c5\u0313\u0301",
+	0x1F56: "\u03c5\u0313\u0342",
+	0x1FB2: "\u1f70\u03b9",
+	0x1FB3: "\u03b1\u03b9",
+	0x1FB4: "\u03ac\u03b9",
+	0x1FB6: "\u03b1\u0342",
+	0x1FB7: "\u03b1\u0342\u03b9",
+	0x1FBC: "\u03b1\u03b9",
+	0x1FC2: "\u1f74\u03b9",
+	0x1FC3: "\u03b7\u03b9",
+	0x1FC4: "\u03ae\u03b9",
+	0x1FC6: "\u03b7\u0342",
+	0x1FC7: "\u03b7\u0342\u03b9",
+	0x1FCC: "\u03b7\u03b9",
+	0x1FD2: "\u03b9\u0308\u0300",
+	0x1FD3: "\u03b9\u0308\u0301",
+	0x1FD6: "\u03b9\u0342",
+	0x1FD7: "\u03b9\u0308\u0342",
+	0x1FE2: "\u03c5\u0308\u0300",
+	0x1FE3: "\u03c5\u0308\u0301",
+	0x1FE4: "\u03c1\u0313",
+	0x1FE6: "\u03c5\u0342",
+	0x1FE7: "\u03c5\u0308\u0342",
+	0x1FF2: "\u1f7c\u03b9",
+	0x1FF3: "\u03c9\u03b9",
+	0x1FF4: "\u03ce\u03b9",
+	0x1FF6: "\u03c9\u0342",
+	0x1FF7: "\u03c9\u0342\u03b9",
+	0x1FFC: "\u03c9\u03b9",
+	0xFB00: "ff",
+	0xFB01: "fi",
+	0xFB02: "fl",
+	0xFB03: "ffi",
+	0xFB04: "ffl",
+	0xFB05: "st",
+	0xFB06: "st",
+	0xFB13: "\u0574\u0576",
+	0xFB14: "\u0574\u0565",
+	0xFB15: "\u0574\u056b",
+	0xFB16: "\u057e\u0576",
+	0xFB17: "\u0574\u056d",
+}
+
+func init() {
+	// Greek letters with ypogegrammeni / prosgegrammeni fold to the base
+	// letter followed by iota, in three blocks of sixteen
+	bases := []rune{0x1F00, 0x1F20, 0x1F60}
+	for i, base := range bases {
+		start := rune(0x1F80 + i*0x10)
+		for j := rune(0); j < 8; j++ {
+			fullFolds[start+j] = string([]rune{base + j, 0x03B9})
+			fullFolds[start+8+j] = string([]rune{base + j, 0x03B9})
+		}
+	}
+}
+
+// foldRune returns the canonical member of r's simple case folding orbit,
+// the smallest rune in it, so that any two case variants fold alike
+func foldRune(r rune) rune {
+	if r < utf8.RuneSelf {
+		if 'a' <= r && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		return r
+	}
+	min := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < min {
+			min = f
+		}
+	}
+	return min
+}
+
+// foldString case folds s into the form compared by foldIndex
+func foldString(s string) []rune {
+	folded := make([]rune, 0, len(s))
+	for _, r := range s {
+		if exp, ok := fullFolds[r]; ok {
+			for _, e := range exp {
+				folded = append(folded, foldRune(e))
+			}
+			continue
+		}
+		folded = append(folded, foldRune(r))
+	}
+	return folded
+}
+
+// foldPrefix reports whether b starts with the folded text pat, and how many
+// bytes of b it spans. Expansions of a single rune must match completely.
+func foldPrefix(b []byte, pat []rune) (int, bool) {
+	n := 0
+	k := 0
+	for k < len(pat) {
+		if n >= len(b) {
+			return 0, false
+		}
+		r, size := utf8.DecodeRune(b[n:])
+		n += size
+		if exp, ok := fullFolds[r]; ok {
+			for _, e := range exp {
+				if k >= len(pat) || foldRune(e) != pat[k] {
+					return 0, false
+				}
+				k++
+			}
+			continue
+		}
+		if foldRune(r) != pat[k] {
+			return 0, false
+		}
+		k++
+	}
+	return n, true
+}
+
+// foldIndex returns the byte span of the first case-insensitive occurrence of
+// the folded text pat in b, or -1, -1 if there is none. b is decoded in place.
+func foldIndex(b []byte, pat []rune) (int, int) {
+	if len(pat) == 0 {
+		return 0, 0
+	}
+	for i := 0; i < len(b); {
+		if n, ok := foldPrefix(b[i:], pat); ok {
+			return i, i + n
+		}
+		_, size := utf8.DecodeRune(b[i:])
+		i += size
+	}
+	return -1, -1
+}
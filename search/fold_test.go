@@ -0,0 +1,61 @@
+package search
+
+import "testing"
+
+func TestFoldString(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Straße", "STRASSE"},
+		{"STRASSE", "STRASSE"},
+		{"ẞ", "SS"},
+		{"\u0130", "I\u0307"},
+		{"ı", "ı"},
+		{"σςΣ", "ΣΣΣ"},
+		{"ﬁ", "FI"},
+		{"K", "K"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := string(foldString(tt.text)); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFoldIndex(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		term       string
+		start, end int
+	}{
+		{"ß against SS", "STRASSE", "straße", 0, 7},
+		{"ß against ss", "strasse", "Straße", 0, 7},
+		{"SS against ß", "Straße", "STRASSE", 0, 7},
+		{"ẞ against ss", "MAẞE", "masse", 0, 6},
+		{"ß against ẞ", "xẞ", "ß", 1, 4},
+		{"half of an expansion", "ß", "s", -1, -1},
+		{"expansion cut by the text", "Stras", "straße", -1, -1},
+		{"İ against i and a dot", "i\u0307stanbul", "İstanbul", 0, 10},
+		{"İ against i", "İstanbul", "istanbul", -1, -1},
+		{"ı against I", "KIRMIZI", "kırmızı", -1, -1},
+		{"ı against ı", "kırmızı", "KıRMıZı", 0, 10},
+		{"final sigma", "ΟΔΥΣΣΕΥΣ", "οδυσσευς", 0, 16},
+		{"capital sigma", "οδυσσευς", "ΟΔΥΣΣΕΥΣ", 0, 16},
+		{"offset after a longer fold", "aßb needle", "NEEDLE", 5, 11},
+		{"offset after a shorter fold", "ﬁx needle", "NEEDLE", 5, 11},
+		{"span of a ligature", "the ﬁnd", "FIND", 4, 9},
+		{"no match", "Straße", "strasser", -1, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := foldIndex([]byte(tt.text), foldString(tt.term))
+			if start != tt.start || end != tt.end {
+				t.Errorf("got %d, %d, want %d, %d", start, end, tt.start, tt.end)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package search
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFiles are read from every searched directory, in this order; a
+// later file overrides an earlier one
+var ignoreFiles = []string{".gitignore", ".ignore", ".gosearchignore"}
+
+// ignoreRule is one pattern line of an ignore file
+type ignoreRule struct {
+	pattern  string // glob, relative to the directory of the ignore file
+	negate   bool   // pattern starts with "!": re-include matching paths
+	dirOnly  bool   // pattern ends with "/": match directories only
+	anchored bool   // pattern has a "/": match the relative path, not the name
+}
+
+// parseIgnoreRule parses a line of an ignore file in gitignore syntax
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	var r ignoreRule
+	line = strings.TrimSuffix(line, "\r")
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || line[0] == '#' {
+		return r, false
+	}
+	if line[0] == '!' {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.HasPrefix(line, "/") {
+		r.anchored = true
+		line = line[1:]
+	} else if strings.Contains(line, "/") {
+		r.anchored = true
+	}
+	if line == "" {
+		return r, false
+	}
+	r.pattern = strings.Replace(line, "[!", "[^", -1)
+	// a trailing "/**" matches what is inside a folder but not the folder
+	// itself, which would otherwise be pruned along with its contents
+	if strings.HasSuffix(r.pattern, "/**") {
+		r.pattern += "/*"
+	}
+	return r, true
+}
+
+// match reports whether the rule matches a path relative to the directory
+// of its ignore file
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.anchored {
+		return matchGlob(r.pattern, rel)
+	}
+	return matchGlob(r.pattern, path.Base(rel))
+}
+
+// ignorer holds the ignore rules of the directories walked so far. It is
+// only used from the walk goroutine.
+type ignorer struct {
+	root  string
+	rules map[string][]ignoreRule // directory -> rules of its ignore files
+}
+
+func newIgnorer(root string) *ignorer {
+	return &ignorer{root: filepath.Clean(root), rules: make(map[string][]ignoreRule)}
+}
+
+// load reads the ignore files of a directory; it must be called before any
+// entry of the directory is checked
+func (ig *ignorer) load(dir string) {
+	dir = filepath.Clean(dir)
+	var rules []ignoreRule
+	for _, name := range ignoreFiles {
+		file, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if r, ok := parseIgnoreRule(scanner.Text()); ok {
+				rules = append(rules, r)
+			}
+		}
+		file.Close()
+	}
+	if len(rules) > 0 {
+		ig.rules[dir] = rules
+	}
+}
+
+// ignored reports whether path is excluded by the ignore files of the
+// directories between it and the root. Rules in deeper directories take
+// precedence, and within a directory the last matching rule decides.
+func (ig *ignorer) ignored(p string, isDir bool) bool {
+	p = filepath.Clean(p)
+	if isDir && filepath.Base(p) == ".git" {
+		return true
+	}
+	for dir := filepath.Dir(p); ; {
+		rules := ig.rules[dir]
+		if len(rules) > 0 {
+			rel, err := filepath.Rel(dir, p)
+			if err == nil {
+				rel = filepath.ToSlash(rel)
+				for i := len(rules) - 1; i >= 0; i-- {
+					if rules[i].match(rel, isDir) {
+						return !rules[i].negate
+					}
+				}
+			}
+		}
+		parent := filepath.Dir(dir)
+		if dir == ig.root || parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
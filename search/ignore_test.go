@@ -0,0 +1,229 @@
+package search
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseIgnoreRule(t *testing.T) {
+	tests := []struct {
+		line string
+		want ignoreRule
+		ok   bool
+	}{
+		{"", ignoreRule{}, false},
+		{"# comment", ignoreRule{}, false},
+		{"   ", ignoreRule{}, false},
+		{"/", ignoreRule{}, false},
+		{"*.log", ignoreRule{pattern: "*.log"}, true},
+		{"*.log  ", ignoreRule{pattern: "*.log"}, true},
+		{"name\\ ", ignoreRule{pattern: "name\\ "}, true},
+		{"*.log\r", ignoreRule{pattern: "*.log"}, true},
+		{"!keep.log", ignoreRule{pattern: "keep.log", negate: true}, true},
+		{"\\!bang", ignoreRule{pattern: "!bang"}, true},
+		{"\\#hash", ignoreRule{pattern: "#hash"}, true},
+		{"build/", ignoreRule{pattern: "build", dirOnly: true}, true},
+		{"/top", ignoreRule{pattern: "top", anchored: true}, true},
+		{"doc/*.txt", ignoreRule{pattern: "doc/*.txt", anchored: true}, true},
+		{"!/out/", ignoreRule{pattern: "out", negate: true, dirOnly: true, anchored: true}, true},
+		{"[!a]*", ignoreRule{pattern: "[^a]*"}, true},
+		{"build/**", ignoreRule{pattern: "build/**/*", anchored: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, ok := parseIgnoreRule(tt.line)
+			if ok != tt.ok || (ok && got != tt.want) {
+				t.Errorf("got %+v, %v, want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+// isIgnoreFile reports whether name is one of ignoreFiles
+func isIgnoreFile(name string) bool {
+	for _, f := range ignoreFiles {
+		if name == f {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTree creates the files of a tree under dir, with their contents
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestIgnored(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string // files searched, other than the ignore files
+	}{
+		{
+			name: "negated rule",
+			files: map[string]string{
+				".gitignore":   "*.log\n!keep.log\n",
+				"a.log":        "",
+				"keep.log":     "",
+				"sub/b.log":    "",
+				"sub/keep.log": "",
+			},
+			want: []string{"keep.log", "sub/keep.log"},
+		},
+		{
+			name: "negated rule before the rule it would override",
+			files: map[string]string{
+				".gitignore": "!keep.log\n*.log\n",
+				"keep.log":   "",
+			},
+			want: nil,
+		},
+		{
+			name: "negated rule under an ignored folder",
+			files: map[string]string{
+				".gitignore":     "build/\n!build/keep.txt\n",
+				"build/keep.txt": "",
+				"main.go":        "",
+			},
+			want: []string{"main.go"},
+		},
+		{
+			name: "negated rule under ignored folder contents",
+			files: map[string]string{
+				".gitignore":     "build/*\n!build/keep.txt\n",
+				"build/keep.txt": "",
+				"build/out.o":    "",
+			},
+			want: []string{"build/keep.txt"},
+		},
+		{
+			name: "negated rule under folder contents matched with **",
+			files: map[string]string{
+				".gitignore":         "build/**\n!build/keep.txt\n",
+				"build/keep.txt":     "",
+				"build/out.o":        "",
+				"build/sub/deep.txt": "",
+			},
+			want: []string{"build/keep.txt"},
+		},
+		{
+			name: "negated rule in a deeper folder",
+			files: map[string]string{
+				".gitignore":     "*.txt\n",
+				"a.txt":          "",
+				"sub/.gitignore": "!*.txt\n",
+				"sub/b.txt":      "",
+			},
+			want: []string{"sub/b.txt"},
+		},
+		{
+			name: "negated rule in a later ignore file",
+			files: map[string]string{
+				".gitignore": "*.md\n",
+				".ignore":    "!README.md\n",
+				"README.md":  "",
+				"NOTES.md":   "",
+			},
+			want: []string{"README.md"},
+		},
+		{
+			name: "anchored rules",
+			files: map[string]string{
+				".gitignore":    "/top.txt\ndoc/*.txt\n",
+				"top.txt":       "",
+				"sub/top.txt":   "",
+				"doc/a.txt":     "",
+				"sub/doc/a.txt": "",
+			},
+			want: []string{"sub/doc/a.txt", "sub/top.txt"},
+		},
+		{
+			name: "folder only rule",
+			files: map[string]string{
+				".gitignore": "out/\n",
+				"out":        "",
+				"sub/out/x":  "",
+			},
+			want: []string{"out"},
+		},
+		{
+			name: "double star",
+			files: map[string]string{
+				".gitignore":     "**/gen/*.go\n",
+				"gen/a.go":       "",
+				"a/b/gen/c.go":   "",
+				"gen/sub/d.go":   "",
+				"a/b/gen/readme": "",
+			},
+			want: []string{"a/b/gen/readme", "gen/sub/d.go"},
+		},
+		{
+			name: "comments and escapes",
+			files: map[string]string{
+				".gitignore": "#note\n\\#hash\n",
+				"#note":      "",
+				"#hash":      "",
+			},
+			want: []string{"#note"},
+		},
+		{
+			name: "git folder",
+			files: map[string]string{
+				".git/config": "",
+				"main.go":     "",
+			},
+			want: []string{"main.go"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "gosearch")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			writeTree(t, dir, tt.files)
+
+			s, err := New(Options{Keyword: "x", ReportAll: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			run := s.Search(context.Background(), dir)
+			var got []string
+			for res := range run.Results() {
+				if res.IsDir || (res.Kind != KindMatch && res.Kind != KindNoMatch) {
+					continue
+				}
+				rel, err := filepath.Rel(dir, res.Path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !isIgnoreFile(filepath.Base(rel)) {
+					got = append(got, filepath.ToSlash(rel))
+				}
+			}
+			if err := run.Summary().Err; err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(got)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package search
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"unicode/utf8"
+)
+
+// Matcher finds the occurrences of a search term. File contents are matched
+// one line at a time, so an occurrence never spans a line break; file and
+// folder names are matched whole.
+type Matcher interface {
+	// Match reports whether text holds an occurrence
+	Match(text []byte) bool
+	// FindAll returns the non-overlapping occurrences in text, from left
+	// to right
+	FindAll(text []byte) []Span
+	// MaxLen returns the longest occurrence, in bytes, that must be found
+	// when a line too long for the read buffer is searched in pieces
+	MaxLen() int
+}
+
+// Span is the byte range [Start, End) of an occurrence
+type Span struct {
+	Start, End int
+}
+
+// MatcherConfig is what a Matcher is built from
+type MatcherConfig struct {
+	Term       string // search term, a query term in query mode
+	IgnoreCase bool   // match with Unicode case folding
+	Distance   int    // edits an approximate matcher allows; 0 for its default
+}
+
+// MatcherFunc builds a Matcher for a search term
+type MatcherFunc func(cfg MatcherConfig) (Matcher, error)
+
+// Names of the built-in matchers
+const (
+	MatchLiteral = "literal" // the term as it is written
+	MatchRegex   = "regex"   // the term is an RE2 regular expression
+	MatchFuzzy   = "fuzzy"   // the term with a few runes inserted, deleted or replaced
+)
+
+var (
+	matchersMu sync.RWMutex
+	matchers   = map[string]MatcherFunc{
+		MatchLiteral: newLiteral,
+		MatchRegex:   newRegex,
+		MatchFuzzy:   newFuzzy,
+	}
+)
+
+// RegisterMatcher makes a matcher available to Options.Matcher by name. It
+// panics if fn is nil or the name is already taken.
+func RegisterMatcher(name string, fn MatcherFunc) {
+	matchersMu.Lock()
+	defer matchersMu.Unlock()
+	if fn == nil {
+		panic("search: RegisterMatcher with nil func for " + name)
+	}
+	if _, dup := matchers[name]; dup {
+		panic("search: RegisterMatcher called twice for " + name)
+	}
+	matchers[name] = fn
+}
+
+// Matchers returns the names of the registered matchers, sorted
+func Matchers() []string {
+	matchersMu.RLock()
+	defer matchersMu.RUnlock()
+	var names []string
+	for name := range matchers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupMatcher returns the registered matcher of a name
+func lookupMatcher(name string) (MatcherFunc, error) {
+	matchersMu.RLock()
+	defer matchersMu.RUnlock()
+	fn, ok := matchers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown matcher %q", name)
+	}
+	return fn, nil
+}
+
+// literal matches a term as it is written, or case folded
+type literal struct {
+	text   []byte
+	folded []rune // set when ignoring case
+}
+
+func newLiteral(cfg MatcherConfig) (Matcher, error) {
+	l := &literal{text: []byte(cfg.Term)}
+	if cfg.IgnoreCase {
+		l.folded = foldString(cfg.Term)
+	}
+	return l, nil
+}
+
+func (l *literal) Match(text []byte) bool {
+	if l.folded != nil {
+		start, _ := foldIndex(text, l.folded)
+		return start >= 0
+	}
+	return bytes.Contains(text, l.text)
+}
+
+func (l *literal) FindAll(text []byte) []Span {
+	var spans []Span
+	for pos := 0; pos < len(text); {
+		var start, end int
+		if l.folded != nil {
+			start, end = foldIndex(text[pos:], l.folded)
+		} else {
+			start = bytes.Index(text[pos:], l.text)
+			end = start + len(l.text)
+		}
+		if start < 0 || end == start {
+			break
+		}
+		spans = append(spans, Span{pos + start, pos + end})
+		pos += end
+	}
+	return spans
+}
+
+// MaxLen allows for folded runes encoded with more bytes than the term's
+func (l *literal) MaxLen() int {
+	if l.folded != nil {
+		return utf8.UTFMax * len(l.folded)
+	}
+	return len(l.text)
+}
+
+// regex matches an RE2 regular expression
+type regex struct {
+	pattern *regexp.Regexp
+}
+
+func newRegex(cfg MatcherConfig) (Matcher, error) {
+	expr := cfg.Term
+	if cfg.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &regex{re}, nil
+}
+
+func (r *regex) Match(text []byte) bool {
+	return r.pattern.Match(text)
+}
+
+func (r *regex) FindAll(text []byte) []Span {
+	var spans []Span
+	for _, loc := range r.pattern.FindAllIndex(text, -1) {
+		spans = append(spans, Span{loc[0], loc[1]})
+	}
+	return spans
+}
+
+// MaxLen assumes a regexp matches at most regexOverlap bytes
+func (r *regex) MaxLen() int {
+	return regexOverlap
+}
+
+// fuzzy matches a term with at most a number of runes inserted, deleted or
+// replaced (Levenshtein distance), using Sellers' dynamic programming
+type fuzzy struct {
+	term       []rune
+	distance   int
+	ignoreCase bool
+}
+
+// newFuzzy allows one edit for every four runes of the term by default,
+// and always fewer edits than the term has runes
+func newFuzzy(cfg MatcherConfig) (Matcher, error) {
+	if cfg.Distance < 0 {
+		return nil, fmt.Errorf("fuzzy distance cannot be negative")
+	}
+	f := &fuzzy{term: []rune(cfg.Term), distance: cfg.Distance, ignoreCase: cfg.IgnoreCase}
+	if f.distance == 0 {
+		f.distance = len(f.term) / 4
+		if f.distance == 0 {
+			f.distance = 1
+		}
+	}
+	if f.distance >= len(f.term) {
+		f.distance = len(f.term) - 1
+	}
+	if f.ignoreCase {
+		for i, r := range f.term {
+			f.term[i] = foldRune(r)
+		}
+	}
+	return f, nil
+}
+
+func (f *fuzzy) Match(text []byte) bool {
+	return len(f.find(text, true)) > 0
+}
+
+func (f *fuzzy) FindAll(text []byte) []Span {
+	return f.find(text, false)
+}
+
+func (f *fuzzy) MaxLen() int {
+	return utf8.UTFMax * (len(f.term) + f.distance)
+}
+
+// find returns the occurrences of the term, or only the first one. Of the
+// ends of a run of close enough matches, the one with the fewest edits is
+// kept.
+func (f *fuzzy) find(text []byte, first bool) []Span {
+	m := len(f.term)
+	// cost[i] is the fewest edits turning term[:i] into text ending at the
+	// current position, and start[i] where that text starts
+	cost := make([]int, m+1)
+	start := make([]int, m+1)
+	next := make([]int, m+1)
+	nextStart := make([]int, m+1)
+	for i := range cost {
+		cost[i] = i
+	}
+
+	var spans []Span
+	var best Span
+	bestCost := -1
+	lastEnd := 0
+	flush := func() {
+		if bestCost >= 0 {
+			spans = append(spans, best)
+			lastEnd = best.End
+			bestCost = -1
+		}
+	}
+	for pos := 0; pos < len(text); {
+		r, size := utf8.DecodeRune(text[pos:])
+		if f.ignoreCase {
+			r = foldRune(r)
+		}
+		pos += size
+		next[0], nextStart[0] = 0, pos
+		for i := 1; i <= m; i++ {
+			c, s := cost[i-1], start[i-1]
+			if f.term[i-1] != r {
+				c++
+			}
+			if cost[i]+1 < c {
+				c, s = cost[i]+1, start[i]
+			}
+			if next[i-1]+1 < c {
+				c, s = next[i-1]+1, nextStart[i-1]
+			}
+			next[i], nextStart[i] = c, s
+		}
+		cost, next = next, cost
+		start, nextStart = nextStart, start
+
+		if cost[m] <= f.distance && start[m] >= lastEnd && start[m] < pos {
+			if bestCost < 0 || cost[m] < bestCost {
+				best, bestCost = Span{start[m], pos}, cost[m]
+			}
+			continue
+		}
+		flush()
+		if first && len(spans) > 0 {
+			return spans
+		}
+	}
+	flush()
+	return spans
+}
@@ -0,0 +1,55 @@
+package search
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFuzzy(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  MatcherConfig
+		text string
+		want []Span
+	}{
+		// a term of six runes allows one edit by default, one of eight two
+		{"default, one edit", MatcherConfig{Term: "needle"}, "a nedle", []Span{{2, 7}}},
+		{"default, two edits", MatcherConfig{Term: "needle"}, "a ndle", nil},
+		{"default of a longer term", MatcherConfig{Term: "searcher"}, "a sarchr", []Span{{2, 8}}},
+		{"default of a longer term, three edits", MatcherConfig{Term: "searcher"}, "a sarch", nil},
+		{"default of a short term", MatcherConfig{Term: "ab"}, "xb", []Span{{0, 2}}},
+		{"single rune matched exactly", MatcherConfig{Term: "a"}, "xyz", nil},
+		{"exact match preferred", MatcherConfig{Term: "needle"}, "needles", []Span{{0, 6}}},
+		{"two occurrences", MatcherConfig{Term: "needle"}, "needle and neddle", []Span{{0, 6}, {11, 17}}},
+		{"explicit distance", MatcherConfig{Term: "needle", Distance: 3}, "a ndl", []Span{{2, 5}}},
+		{"explicit distance below the default", MatcherConfig{Term: "searcher", Distance: 1}, "a sarchr", nil},
+		{"explicit distance capped by the term", MatcherConfig{Term: "abc", Distance: 5}, "xyz", nil},
+		{"case counts as an edit", MatcherConfig{Term: "needle"}, "NEEDLE", nil},
+		{"ignoring case", MatcherConfig{Term: "needle", IgnoreCase: true}, "NEEDLE", []Span{{0, 6}}},
+		{"ignoring case, one edit", MatcherConfig{Term: "Needle", IgnoreCase: true}, "a nEDLE", []Span{{2, 7}}},
+		{"ignoring case, explicit distance", MatcherConfig{Term: "needle", Distance: 2, IgnoreCase: true}, "NEDLX", []Span{{0, 4}}},
+		{"ignoring case, beyond the distance", MatcherConfig{Term: "needle", IgnoreCase: true}, "NEDLX", nil},
+		{"multibyte runes", MatcherConfig{Term: "élève", IgnoreCase: true}, "un ÉLEVE", []Span{{3, 9}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newFuzzy(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := m.FindAll([]byte(tt.text))
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if m.Match([]byte(tt.text)) != (len(tt.want) > 0) {
+				t.Errorf("Match disagrees with FindAll")
+			}
+		})
+	}
+}
+
+func TestFuzzyNegativeDistance(t *testing.T) {
+	if _, err := newFuzzy(MatcherConfig{Term: "needle", Distance: -1}); err == nil {
+		t.Error("got no error for a negative distance")
+	}
+}
@@ -0,0 +1,354 @@
+package search
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// OpenDocument namespaces
+const (
+	odfNSOffice       = "urn:oasis:names:tc:opendocument:xmlns:office:1.0"
+	odfNSStyle        = "urn:oasis:names:tc:opendocument:xmlns:style:1.0"
+	odfNSText         = "urn:oasis:names:tc:opendocument:xmlns:text:1.0"
+	odfNSTable        = "urn:oasis:names:tc:opendocument:xmlns:table:1.0"
+	odfNSDraw         = "urn:oasis:names:tc:opendocument:xmlns:drawing:1.0"
+	odfNSPresentation = "urn:oasis:names:tc:opendocument:xmlns:presentation:1.0"
+	odfNSSVG          = "urn:oasis:names:tc:opendocument:xmlns:svg-compatible:1.0"
+	odfNSDC           = "http://purl.org/dc/elements/1.1/"
+)
+
+// odfSkipped are the elements whose text is not part of the document's
+// text: deleted text kept for change tracking, the authors and dates of
+// comments, and the titles and descriptions of drawings
+var odfSkipped = map[xml.Name]bool{
+	{Space: odfNSText, Local: "tracked-changes"}: true,
+	{Space: odfNSDC, Local: "creator"}:           true,
+	{Space: odfNSDC, Local: "date"}:              true,
+	{Space: odfNSSVG, Local: "title"}:            true,
+	{Space: odfNSSVG, Local: "desc"}:             true,
+}
+
+// maxODFSpaces bounds the spaces written for one text:s element, whose
+// count comes from the file
+const maxODFSpaces = 1024
+
+// extractODF writes the text of an OpenDocument text document, spreadsheet
+// or presentation, read from the content.xml part of its zip container. A
+// text document is written in section "body", then its page headers and
+// footers; each cell of a spreadsheet in a section named like those of xlsx
+// files, such as "Sheet2!C14"; each slide of a presentation in "slide N",
+// and its notes in "slide N notes".
+func extractODF(r io.ReaderAt, size int64, doc *document) error {
+	zr, err := openZip(r, size, "ODF")
+	if err != nil {
+		return err
+	}
+	encrypted := false
+	err = readPart(zr, "META-INF/manifest.xml", "ODF", func(dec *xml.Decoder) error {
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "encryption-data" {
+				encrypted = true
+				return nil
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if encrypted {
+		return docError("ODF", "encrypted")
+	}
+	content := &odfContent{w: odfWriter{doc: doc}}
+	if err := readPart(zr, "content.xml", "ODF", content.read); err != nil {
+		return err
+	}
+	if content.kind == "" {
+		return docError("ODF", "no document body")
+	}
+	if content.kind == "text" {
+		return readPart(zr, "styles.xml", "ODF", content.readMasterPages)
+	}
+	return nil
+}
+
+// odfContent is the state of the reading of content.xml
+type odfContent struct {
+	w     odfWriter
+	kind  string // text, spreadsheet or presentation
+	slide int
+	sheet string // current sheet, as written in cell references
+	row   int    // 0-based row of the current table row
+	cells []odfCell
+	col   int // 0-based column of the current cell
+	cell  odfCell
+}
+
+// odfCell is a non-empty spreadsheet cell, repeated over columns
+type odfCell struct {
+	col, repeat int
+	text        string
+}
+
+// read writes the text of the document body
+func (c *odfContent) read(dec *xml.Decoder) error {
+	rows := 1
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			c.w.doc.endLine()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if c.w.skipping(tok) {
+			continue
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			switch tok.Name {
+			case xml.Name{Space: odfNSOffice, Local: "text"}:
+				c.kind = "text"
+				c.w.doc.begin("body")
+			case xml.Name{Space: odfNSOffice, Local: "spreadsheet"}:
+				c.kind = "spreadsheet"
+			case xml.Name{Space: odfNSOffice, Local: "presentation"}:
+				c.kind = "presentation"
+			case xml.Name{Space: odfNSDraw, Local: "page"}:
+				if c.kind == "presentation" {
+					c.slide++
+					c.w.doc.begin("slide " + strconv.Itoa(c.slide))
+				}
+			case xml.Name{Space: odfNSPresentation, Local: "notes"}:
+				c.w.doc.begin("slide " + strconv.Itoa(c.slide) + " notes")
+			case xml.Name{Space: odfNSTable, Local: "table"}:
+				if c.kind == "spreadsheet" {
+					c.sheet, c.row = sheetRef(attr(tok, "name")), 0
+				}
+			case xml.Name{Space: odfNSTable, Local: "table-row"}:
+				rows = repeated(tok, "number-rows-repeated")
+				c.cells, c.col = c.cells[:0], 0
+			case xml.Name{Space: odfNSTable, Local: "table-cell"}, xml.Name{Space: odfNSTable, Local: "covered-table-cell"}:
+				c.cell = odfCell{col: c.col, repeat: repeated(tok, "number-columns-repeated"), text: attr(tok, "value")}
+				if c.kind == "spreadsheet" {
+					c.w.cell = true
+				}
+			default:
+				c.w.token(tok)
+			}
+		case xml.EndElement:
+			switch tok.Name {
+			case xml.Name{Space: odfNSTable, Local: "table-cell"}, xml.Name{Space: odfNSTable, Local: "covered-table-cell"}:
+				if c.w.cell {
+					// the displayed text, rather than the value, if there is one
+					if text := strings.TrimRight(string(c.w.buf), "\n"); text != "" {
+						c.cell.text = text
+					}
+					if c.cell.text != "" {
+						c.cells = append(c.cells, c.cell)
+					}
+					c.w.cell, c.w.buf = false, c.w.buf[:0]
+				}
+				c.col += c.cell.repeat
+			case xml.Name{Space: odfNSTable, Local: "table-row"}:
+				if c.kind == "spreadsheet" {
+					c.writeRows(rows)
+				}
+				c.row += rows
+			default:
+				c.w.token(tok)
+			}
+		default:
+			c.w.token(tok)
+		}
+	}
+}
+
+// writeRows writes the cells of a table row, repeated over rows. Repeats
+// stop at the number of cells the text and section limits leave room for,
+// each cell taking a section and at least a character and a newline; the
+// document is then full.
+func (c *odfContent) writeRows(rows int) {
+	doc := c.w.doc
+	room := (maxDocumentText - len(doc.text)) / 2
+	if n := maxDocumentSections - len(doc.sections); n < room {
+		room = n
+	}
+	for i := 0; i < rows && len(c.cells) > 0 && !doc.full; i++ {
+		for _, cell := range c.cells {
+			for j := 0; j < cell.repeat && !doc.full; j++ {
+				if room == 0 {
+					doc.full = true
+					return
+				}
+				doc.begin(c.sheet + "!" + columnName(cell.col+j+1) + strconv.Itoa(c.row+i+1))
+				doc.WriteString(cell.text)
+				room--
+			}
+		}
+	}
+}
+
+// readMasterPages writes the page headers and footers of a text document,
+// in sections named after them, such as "header" or "footer-left"
+func (c *odfContent) readMasterPages(dec *xml.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			c.w.doc.endLine()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if c.w.skipping(tok) {
+			continue
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if headerFooter(tok.Name) {
+				if depth == 0 {
+					c.w.doc.begin(tok.Name.Local)
+				}
+				depth++
+				continue
+			}
+		case xml.EndElement:
+			if headerFooter(tok.Name) {
+				depth--
+				continue
+			}
+		}
+		if depth > 0 {
+			c.w.token(tok)
+		}
+	}
+}
+
+// odfHeadersFooters are the elements of a master page holding its headers
+// and footers
+var odfHeadersFooters = map[string]bool{
+	"header": true, "header-left": true, "header-first": true,
+	"footer": true, "footer-left": true, "footer-first": true,
+}
+
+// headerFooter reports whether an element holds a page header or footer
+func headerFooter(name xml.Name) bool {
+	return name.Space == odfNSStyle && odfHeadersFooters[name.Local]
+}
+
+// repeated returns the repeat count of a table row or cell
+func repeated(se xml.StartElement, name string) int {
+	n, err := strconv.Atoi(attr(se, name))
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+// odfWriter writes the text of OpenDocument paragraphs, each on a line of
+// its own. Spans and links within a paragraph are written as they come, so
+// that a word split across differently styled spans stays whole. White
+// space is collapsed as OpenDocument readers do: a run of spaces, tabs and
+// newlines is one space, and there is none at the start of a paragraph;
+// text:s, text:tab and text:line-break elements stand for the others.
+type odfWriter struct {
+	doc   *document
+	cell  bool   // in a spreadsheet cell, whose text is kept in buf
+	buf   []byte // text of the current cell
+	para  int    // depth of paragraphs and headings
+	skip  int    // depth in skipped elements
+	space bool   // the last character written was white space
+}
+
+// skipping reports whether tok is in an element whose text is left out
+func (w *odfWriter) skipping(tok xml.Token) bool {
+	switch tok := tok.(type) {
+	case xml.StartElement:
+		if w.skip > 0 || odfSkipped[tok.Name] {
+			w.skip++
+		}
+	case xml.EndElement:
+		if w.skip > 0 {
+			w.skip--
+			return true
+		}
+	}
+	return w.skip > 0
+}
+
+func (w *odfWriter) token(tok xml.Token) {
+	switch tok := tok.(type) {
+	case xml.StartElement:
+		if tok.Name.Space != odfNSText {
+			return
+		}
+		switch tok.Name.Local {
+		case "p", "h":
+			// a paragraph within another, such as that of a note, starts
+			// on a new line
+			if w.para > 0 {
+				w.write("\n")
+			}
+			w.para++
+			w.space = true
+		case "s":
+			n := repeated(tok, "c")
+			if n > maxODFSpaces {
+				n = maxODFSpaces
+			}
+			w.write(strings.Repeat(" ", n))
+			w.space = true
+		case "tab":
+			w.write("\t")
+			w.space = true
+		case "line-break":
+			w.write("\n")
+			w.space = true
+		}
+	case xml.EndElement:
+		if tok.Name.Space == odfNSText && (tok.Name.Local == "p" || tok.Name.Local == "h") && w.para > 0 {
+			w.para--
+			w.write("\n")
+			w.space = true
+		}
+	case xml.CharData:
+		if w.para == 0 {
+			return
+		}
+		text := make([]byte, 0, len(tok))
+		for _, b := range tok {
+			switch b {
+			case ' ', '\t', '\r', '\n':
+				if !w.space {
+					text = append(text, ' ')
+					w.space = true
+				}
+			default:
+				text = append(text, b)
+				w.space = false
+			}
+		}
+		w.write(string(text))
+	}
+}
+
+func (w *odfWriter) write(s string) {
+	if w.cell {
+		if len(w.buf) < maxDocumentText {
+			w.buf = append(w.buf, s...)
+		}
+		return
+	}
+	w.doc.WriteString(s)
+}
@@ -0,0 +1,80 @@
+package search
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// testODS builds a spreadsheet whose content.xml holds the rows of a sheet
+func testODS(t *testing.T, rows string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	parts := map[string]string{
+		"META-INF/manifest.xml": `<manifest:manifest xmlns:manifest="urn:oasis:names:tc:opendocument:xmlns:manifest:1.0"/>`,
+		"content.xml": `<office:document-content xmlns:office="` + odfNSOffice + `" xmlns:table="` + odfNSTable + `" xmlns:text="` + odfNSText + `">` +
+			`<office:body><office:spreadsheet><table:table table:name="Sheet1">` + rows +
+			`</table:table></office:spreadsheet></office:body></office:document-content>`,
+	}
+	for name, data := range parts {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(data))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestExtractODS(t *testing.T) {
+	tests := []struct {
+		name string
+		rows string
+		want []string
+	}{
+		{
+			name: "cells",
+			rows: `<table:table-row><table:table-cell><text:p>Revenue</text:p></table:table-cell><table:table-cell table:number-columns-repeated="2"/>` +
+				`<table:table-cell office:value="42"><text:p>42.00</text:p></table:table-cell></table:table-row>`,
+			want: []string{"Sheet1!A1: Revenue", "Sheet1!D1: 42.00"},
+		},
+		{
+			name: "repeated cells",
+			rows: `<table:table-row table:number-rows-repeated="2"><table:table-cell table:number-columns-repeated="2"><text:p>x</text:p></table:table-cell></table:table-row>` +
+				`<table:table-row table:number-rows-repeated="1000000"/>` +
+				`<table:table-row><table:table-cell><text:p>end</text:p></table:table-cell></table:table-row>`,
+			want: []string{"Sheet1!A1: x", "Sheet1!B1: x", "Sheet1!A2: x", "Sheet1!B2: x", "Sheet1!A1000003: end"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := testODS(t, tt.rows)
+			doc := &document{limit: DefaultMaxDocumentSize}
+			if err := extract(extractODF, bytes.NewReader(data), int64(len(data)), doc); err != nil {
+				t.Fatalf("extractODF: %v", err)
+			}
+			if got := docLines(doc); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestExtractODSRepeats checks that a cell repeated over a whole sheet
+// stops at the section limit rather than taking up unbounded memory
+func TestExtractODSRepeats(t *testing.T) {
+	data := testODS(t, `<table:table-row table:number-rows-repeated="1000000000">`+
+		`<table:table-cell office:value="0" table:number-columns-repeated="1000000000"/></table:table-row>`)
+	doc := &document{limit: DefaultMaxDocumentSize}
+	if err := extract(extractODF, bytes.NewReader(data), int64(len(data)), doc); err != nil {
+		t.Fatalf("extractODF: %v", err)
+	}
+	if len(doc.sections) != maxDocumentSections || !doc.full {
+		t.Errorf("got %d sections, full %v, want %d, full", len(doc.sections), doc.full, maxDocumentSections)
+	}
+}
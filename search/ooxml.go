@@ -0,0 +1,503 @@
+package search
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxPartSize bounds the uncompressed size of one part of a zip based
+// document, so that a zip bomb is refused before it is inflated
+const maxPartSize = 256 << 20
+
+// Relationship types of Office Open XML parts
+const (
+	relOfficeDocument = "/officeDocument"
+	relNotesSlide     = "/notesSlide"
+)
+
+// openZip opens a zip based document
+func openZip(r io.ReaderAt, size int64, format string) (*zip.Reader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, docError(format, "not a zip container: %v", err)
+	}
+	return zr, nil
+}
+
+// openPart opens a part of a zip based document by name, or returns nil if
+// there is no such part
+func openPart(zr *zip.Reader, name, format string) (io.ReadCloser, error) {
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		if f.UncompressedSize64 > maxPartSize {
+			return nil, docError(format, "%s larger than %d MiB", name, maxPartSize>>20)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, docError(format, "%s: %v", name, err)
+		}
+		return rc, nil
+	}
+	return nil, nil
+}
+
+// readPart decodes a part of a zip based document with fn, doing nothing if
+// there is no such part
+func readPart(zr *zip.Reader, name, format string, fn func(dec *xml.Decoder) error) error {
+	rc, err := openPart(zr, name, format)
+	if rc == nil {
+		return err
+	}
+	defer rc.Close()
+	if err := fn(xml.NewDecoder(rc)); err != nil {
+		if _, ok := err.(*formatError); ok {
+			return err
+		}
+		return docError(format, "%s: %v", name, err)
+	}
+	return nil
+}
+
+// relationship is a link from one part of an Office Open XML package to
+// another
+type relationship struct {
+	id, typ, target string
+}
+
+// readRels reads the relationships of a part, with targets resolved to part
+// names. External targets are left out.
+func readRels(zr *zip.Reader, part, format string) ([]relationship, error) {
+	dir, file := path.Split(part)
+	var rels []relationship
+	err := readPart(zr, dir+"_rels/"+file+".rels", format, func(dec *xml.Decoder) error {
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			se, ok := tok.(xml.StartElement)
+			if !ok || se.Name.Local != "Relationship" || attr(se, "TargetMode") == "External" {
+				continue
+			}
+			target := attr(se, "Target")
+			if strings.HasPrefix(target, "/") {
+				target = target[1:]
+			} else {
+				target = path.Join(dir, target)
+			}
+			rels = append(rels, relationship{attr(se, "Id"), attr(se, "Type"), target})
+		}
+	})
+	return rels, err
+}
+
+// mainPart returns the name of the main part of a package, or def if the
+// package does not say
+func mainPart(zr *zip.Reader, def, format string) (string, error) {
+	rels, err := readRels(zr, "", format)
+	if err != nil {
+		return "", err
+	}
+	for _, rel := range rels {
+		if strings.HasSuffix(rel.typ, relOfficeDocument) {
+			return rel.target, nil
+		}
+	}
+	return def, nil
+}
+
+// attr returns the value of an attribute by local name
+func attr(se xml.StartElement, local string) string {
+	for _, a := range se.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// relID returns the r:id attribute of an element, which refers to a
+// relationship of its part
+func relID(se xml.StartElement) string {
+	for _, a := range se.Attr {
+		if a.Name.Local == "id" && strings.HasSuffix(a.Name.Space, "relationships") {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// writeRuns writes the text runs of a WordprocessingML or DrawingML part:
+// the contents of t elements, with tabs and breaks, and each paragraph on a
+// line of its own. Deleted text and field codes, held in other elements,
+// are left out, as are the copies of text boxes kept for older readers.
+// Only a tab within a run is written: the tab stops of paragraph properties
+// are tab elements too.
+func writeRuns(doc *document) func(dec *xml.Decoder) error {
+	return func(dec *xml.Decoder) error {
+		inText := false
+		inRun := 0
+		fallback := 0
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				doc.endLine()
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "Fallback" {
+				fallback++
+			}
+			if ee, ok := tok.(xml.EndElement); ok && ee.Name.Local == "Fallback" {
+				fallback--
+			}
+			if fallback > 0 {
+				continue
+			}
+			switch tok := tok.(type) {
+			case xml.StartElement:
+				switch tok.Name.Local {
+				case "t":
+					inText = true
+				case "r":
+					inRun++
+				case "tab":
+					if inRun > 0 {
+						doc.WriteString("\t")
+					}
+				case "br", "cr":
+					doc.WriteString("\n")
+				}
+			case xml.EndElement:
+				switch tok.Name.Local {
+				case "t":
+					inText = false
+				case "r":
+					inRun--
+				case "p":
+					doc.WriteString("\n")
+				}
+			case xml.CharData:
+				if inText {
+					doc.WriteString(string(tok))
+				}
+			}
+		}
+	}
+}
+
+// extractDOCX writes the text of a Word document: the body, in section
+// "body", then its headers, footers, footnotes, endnotes and comments, in
+// sections named after their parts, such as "header1"
+func extractDOCX(r io.ReaderAt, size int64, doc *document) error {
+	zr, err := openZip(r, size, "DOCX")
+	if err != nil {
+		return err
+	}
+	main, err := mainPart(zr, "word/document.xml", "DOCX")
+	if err != nil {
+		return err
+	}
+	doc.begin("body")
+	if err := readPart(zr, main, "DOCX", writeRuns(doc)); err != nil {
+		return err
+	}
+
+	rels, err := readRels(zr, main, "DOCX")
+	if err != nil {
+		return err
+	}
+	sort.Slice(rels, func(i, j int) bool { return rels[i].target < rels[j].target })
+	for _, kind := range []string{"/header", "/footer", "/footnotes", "/endnotes", "/comments"} {
+		for _, rel := range rels {
+			if !strings.HasSuffix(rel.typ, kind) {
+				continue
+			}
+			doc.begin(strings.TrimSuffix(path.Base(rel.target), ".xml"))
+			if err := readPart(zr, rel.target, "DOCX", writeRuns(doc)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// extractPPTX writes the text of a presentation, each slide in a section
+// "slide N" followed by its notes in "slide N notes"
+func extractPPTX(r io.ReaderAt, size int64, doc *document) error {
+	zr, err := openZip(r, size, "PPTX")
+	if err != nil {
+		return err
+	}
+	main, err := mainPart(zr, "ppt/presentation.xml", "PPTX")
+	if err != nil {
+		return err
+	}
+	rels, err := readRels(zr, main, "PPTX")
+	if err != nil {
+		return err
+	}
+	targets := make(map[string]string)
+	for _, rel := range rels {
+		targets[rel.id] = rel.target
+	}
+
+	// slides in the order of the slide list
+	var slides []string
+	err = readPart(zr, main, "PPTX", func(dec *xml.Decoder) error {
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "sldId" {
+				if target, ok := targets[relID(se)]; ok {
+					slides = append(slides, target)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, slide := range slides {
+		location := "slide " + strconv.Itoa(i+1)
+		doc.begin(location)
+		if err := readPart(zr, slide, "PPTX", writeRuns(doc)); err != nil {
+			return err
+		}
+		rels, err := readRels(zr, slide, "PPTX")
+		if err != nil {
+			return err
+		}
+		for _, rel := range rels {
+			if strings.HasSuffix(rel.typ, relNotesSlide) {
+				doc.begin(location + " notes")
+				if err := readPart(zr, rel.target, "PPTX", writeRuns(doc)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// extractXLSX writes the text of a workbook, each cell that has a value in
+// a section named by its reference, such as "Sheet2!C14"
+func extractXLSX(r io.ReaderAt, size int64, doc *document) error {
+	zr, err := openZip(r, size, "XLSX")
+	if err != nil {
+		return err
+	}
+	main, err := mainPart(zr, "xl/workbook.xml", "XLSX")
+	if err != nil {
+		return err
+	}
+	rels, err := readRels(zr, main, "XLSX")
+	if err != nil {
+		return err
+	}
+	targets := make(map[string]string)
+	var sharedStrings string
+	for _, rel := range rels {
+		targets[rel.id] = rel.target
+		if strings.HasSuffix(rel.typ, "/sharedStrings") {
+			sharedStrings = rel.target
+		}
+	}
+
+	var shared []string
+	if sharedStrings != "" {
+		if err := readPart(zr, sharedStrings, "XLSX", readSharedStrings(&shared)); err != nil {
+			return err
+		}
+	}
+
+	// sheets in the order of the workbook
+	type sheet struct{ name, part string }
+	var sheets []sheet
+	err = readPart(zr, main, "XLSX", func(dec *xml.Decoder) error {
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "sheet" {
+				if target, ok := targets[relID(se)]; ok {
+					sheets = append(sheets, sheet{attr(se, "name"), target})
+				}
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, s := range sheets {
+		if err := readPart(zr, s.part, "XLSX", writeCells(doc, sheetRef(s.name), shared)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSharedStrings reads the shared string table of a workbook, leaving
+// out phonetic runs
+func readSharedStrings(shared *[]string) func(dec *xml.Decoder) error {
+	return func(dec *xml.Decoder) error {
+		var text []byte
+		inText, phonetic := false, 0
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			switch tok := tok.(type) {
+			case xml.StartElement:
+				switch tok.Name.Local {
+				case "si":
+					text = text[:0]
+				case "t":
+					inText = true
+				case "rPh":
+					phonetic++
+				}
+			case xml.EndElement:
+				switch tok.Name.Local {
+				case "si":
+					*shared = append(*shared, string(text))
+				case "t":
+					inText = false
+				case "rPh":
+					phonetic--
+				}
+			case xml.CharData:
+				if inText && phonetic == 0 {
+					text = append(text, tok...)
+				}
+			}
+		}
+	}
+}
+
+// writeCells writes the value of each cell of a worksheet, in a section
+// named by the sheet and the cell
+func writeCells(doc *document, sheet string, shared []string) func(dec *xml.Decoder) error {
+	return func(dec *xml.Decoder) error {
+		var value []byte
+		var ref, typ string
+		row, col := 0, 0
+		inValue := false
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			switch tok := tok.(type) {
+			case xml.StartElement:
+				switch tok.Name.Local {
+				case "row":
+					row++
+					if n, err := strconv.Atoi(attr(tok, "r")); err == nil {
+						row = n
+					}
+					col = 0
+				case "c":
+					col++
+					ref, typ = attr(tok, "r"), attr(tok, "t")
+					if c, ok := cellColumn(ref); ok {
+						col = c
+					} else {
+						ref = columnName(col) + strconv.Itoa(row)
+					}
+					value = value[:0]
+				case "v", "t":
+					inValue = true
+				}
+			case xml.EndElement:
+				switch tok.Name.Local {
+				case "v", "t":
+					inValue = false
+				case "c":
+					text := string(value)
+					switch typ {
+					case "s":
+						n, err := strconv.Atoi(text)
+						if err != nil || n < 0 || n >= len(shared) {
+							continue
+						}
+						text = shared[n]
+					case "b":
+						if text == "1" {
+							text = "TRUE"
+						} else {
+							text = "FALSE"
+						}
+					}
+					if text != "" {
+						doc.begin(sheet + "!" + ref)
+						doc.WriteString(text)
+					}
+				}
+			case xml.CharData:
+				if inValue {
+					value = append(value, tok...)
+				}
+			}
+		}
+	}
+}
+
+// cellColumn returns the 1-based column of a cell reference such as "C14"
+func cellColumn(ref string) (int, bool) {
+	col, i := 0, 0
+	for ; i < len(ref) && ref[i] >= 'A' && ref[i] <= 'Z'; i++ {
+		col = col*26 + int(ref[i]-'A') + 1
+	}
+	return col, i > 0 && i < len(ref)
+}
+
+// columnName returns the letters of a 1-based column, such as "AA" for 27
+func columnName(col int) string {
+	var name []byte
+	for ; col > 0; col = (col - 1) / 26 {
+		name = append([]byte{byte('A' + (col-1)%26)}, name...)
+	}
+	return string(name)
+}
+
+// sheetRef returns a sheet name as written in a cell reference, quoted if
+// it holds other than letters, digits and underscores
+func sheetRef(name string) string {
+	for _, r := range name {
+		if r != '_' && !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+			return "'" + strings.Replace(name, "'", "''", -1) + "'"
+		}
+	}
+	return name
+}
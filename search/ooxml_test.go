@@ -0,0 +1,45 @@
+package search
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestWriteRuns(t *testing.T) {
+	const w = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"`
+	tests := []struct {
+		name string
+		part string
+		want []string
+	}{
+		{
+			name: "tab in a run",
+			part: `<w:document ` + w + `><w:body><w:p><w:r><w:t>Name</w:t><w:tab/><w:t>Value</w:t></w:r></w:p></w:body></w:document>`,
+			want: []string{"body: Name\tValue"},
+		},
+		{
+			name: "tab stops",
+			part: `<w:document ` + w + `><w:body><w:p><w:pPr><w:tabs><w:tab w:val="left" w:pos="720"/><w:tab w:val="right" w:pos="9360"/></w:tabs></w:pPr>` +
+				`<w:r><w:t>Name</w:t></w:r><w:r><w:tab/></w:r><w:r><w:t>Value</w:t></w:r></w:p></w:body></w:document>`,
+			want: []string{"body: Name\tValue"},
+		},
+		{
+			name: "breaks and paragraphs",
+			part: `<w:document ` + w + `><w:body><w:p><w:r><w:t>one</w:t><w:br/><w:t>two</w:t></w:r></w:p><w:p><w:r><w:t>three</w:t></w:r></w:p></w:body></w:document>`,
+			want: []string{"body: one", "body: two", "body: three"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := &document{limit: DefaultMaxDocumentSize}
+			doc.begin("body")
+			if err := writeRuns(doc)(xml.NewDecoder(strings.NewReader(tt.part))); err != nil {
+				t.Fatalf("writeRuns: %v", err)
+			}
+			if got := docLines(doc); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package search
+
+import (
+	"context"
+	"sort"
+)
+
+// Orders of the results, see Options.Sort
+const (
+	SortPath    = "path"    // walk order: roots as given, entries by name, folders before their contents
+	SortSize    = "size"    // matches by size, smallest first
+	SortModTime = "mtime"   // matches by modification time, oldest first
+	SortMatches = "matches" // matches by number of occurrences, fewest first
+)
+
+// slotsPerWorker bounds how far the walk may run ahead of the results sent
+// in order, so that one slow file does not make the rest of the tree pile
+// up in memory
+const slotsPerWorker = 64
+
+// slotResults are the results of one walked path, or of one path skipped or
+// failed by the walk
+type slotResults struct {
+	slot    int
+	results []Result
+}
+
+// orderer sends results in walk order, whatever order the workers finish
+// in. Each walked path takes a slot, numbered as the walk goes; the results
+// of a slot are sent once those of every earlier slot have been. Sorting by
+// other than path holds the matches back and sends them, sorted, once the
+// search is done; ties keep walk order.
+type orderer struct {
+	w      *walker
+	sortBy string
+	window chan struct{}    // one token per slot not yet sent
+	slots  chan slotResults // results of finished slots
+	done   chan struct{}    // closed when every result is sent
+	next   int              // next slot handed out, by the walk only
+}
+
+func newOrderer(w *walker, sortBy string, workers int) *orderer {
+	o := &orderer{
+		w:      w,
+		sortBy: sortBy,
+		window: make(chan struct{}, slotsPerWorker*workers),
+		slots:  make(chan slotResults, workers),
+		done:   make(chan struct{}),
+	}
+	go o.run()
+	return o
+}
+
+// slot hands out the next slot, waiting while too many are not sent yet
+func (o *orderer) slot(ctx context.Context) (int, error) {
+	select {
+	case o.window <- struct{}{}:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	o.next++
+	return o.next - 1, nil
+}
+
+// finish hands in the results of a slot, which may be none
+func (o *orderer) finish(slot int, results []Result) {
+	o.slots <- slotResults{slot, results}
+}
+
+// close ends the results once every slot is finished
+func (o *orderer) close() {
+	close(o.slots)
+	<-o.done
+}
+
+func (o *orderer) run() {
+	defer close(o.done)
+	pending := make(map[int][]Result)
+	var held []Result
+	sent := 0
+	for s := range o.slots {
+		pending[s.slot] = s.results
+		for {
+			results, ok := pending[sent]
+			if !ok {
+				break
+			}
+			delete(pending, sent)
+			sent++
+			<-o.window
+			for _, res := range results {
+				if o.sortBy != SortPath && (res.Kind == KindMatch || res.Kind == KindNoMatch) {
+					held = append(held, res)
+					continue
+				}
+				o.w.send(res)
+			}
+		}
+	}
+
+	var less func(a, b Result) bool
+	switch o.sortBy {
+	case SortSize:
+		less = func(a, b Result) bool { return a.Size < b.Size }
+	case SortModTime:
+		less = func(a, b Result) bool { return a.ModTime.Before(b.ModTime) }
+	case SortMatches:
+		less = func(a, b Result) bool { return len(a.Matches) < len(b.Matches) }
+	default:
+		return
+	}
+	sort.SliceStable(held, func(i, j int) bool { return less(held[i], held[j]) })
+	for _, res := range held {
+		o.w.send(res)
+	}
+}
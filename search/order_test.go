@@ -0,0 +1,36 @@
+package search
+
+import (
+	"context"
+	"testing"
+)
+
+// TestOrderCancel checks that the results of a slot searched before the
+// search was cancelled are sent, even though an earlier slot still queued
+// is never searched
+func TestOrderCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := make(chan Result, 4)
+	w := &walker{ctx: ctx, results: results}
+	w.order = newOrderer(w, SortPath, 2)
+
+	queued, _ := w.order.slot(context.Background())
+	searched, _ := w.order.slot(context.Background())
+	w.order.finish(searched, []Result{{Kind: KindMatch, Path: "searched"}})
+	jobs := make(chan job, 1)
+	jobs <- job{slot: queued}
+	close(jobs)
+	w.wg.Add(1)
+	w.work(jobs)
+	w.order.close()
+	close(results)
+
+	var got []string
+	for res := range results {
+		got = append(got, res.Path)
+	}
+	if len(got) != 1 || got[0] != "searched" {
+		t.Errorf("got %q, want [searched]", got)
+	}
+}
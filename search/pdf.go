@@ -0,0 +1,937 @@
+package search
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// maxPDFStream bounds the decoded size of one PDF stream
+const maxPDFStream = 64 << 20
+
+// pdfObject is a PDF object: nil, bool, int64, float64, pdfName, pdfString,
+// pdfArray, pdfDict, pdfRef or *pdfStream. Content streams also hold
+// operators, read as pdfOp.
+type pdfObject interface{}
+
+type (
+	pdfName   string
+	pdfString []byte
+	pdfArray  []pdfObject
+	pdfDict   map[pdfName]pdfObject
+	pdfOp     string // operator, keyword or closing delimiter
+)
+
+// pdfRef refers to an indirect object
+type pdfRef struct {
+	num, gen int
+}
+
+// pdfStream is a stream object, with its data still encoded
+type pdfStream struct {
+	dict pdfDict
+	data []byte
+}
+
+var errPDFEnd = errors.New("unexpected end of data")
+
+// pdfLexer reads PDF objects from a byte slice
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func isPDFSpace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isPDFDelim(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+// skipSpace skips white space and comments
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		if !isPDFSpace(c) {
+			return
+		}
+		l.pos++
+	}
+}
+
+// object reads the next object. Keywords, operators and the closing
+// delimiters "]" and ">>" are returned as pdfOp.
+func (l *pdfLexer) object(depth int) (pdfObject, error) {
+	if depth > 64 {
+		return nil, errors.New("objects nested too deep")
+	}
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, errPDFEnd
+	}
+	c := l.data[l.pos]
+	switch {
+	case c == '/':
+		return l.name(), nil
+	case c == '(':
+		return l.literal(), nil
+	case c == '<':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '<' {
+			l.pos += 2
+			return l.dict(depth)
+		}
+		return l.hex(), nil
+	case c == '>':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '>' {
+			l.pos += 2
+			return pdfOp(">>"), nil
+		}
+	case c == '[':
+		l.pos++
+		return l.array(depth)
+	case c == '+' || c == '-' || c == '.' || c >= '0' && c <= '9':
+		return l.number(), nil
+	}
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelim(l.data[l.pos]) {
+		l.pos++
+	}
+	if l.pos == start {
+		// a delimiter out of place
+		l.pos++
+	}
+	switch word := string(l.data[start:l.pos]); word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	default:
+		return pdfOp(word), nil
+	}
+}
+
+// name reads a name, decoding #xx escapes
+func (l *pdfLexer) name() pdfName {
+	l.pos++
+	var name []byte
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelim(l.data[l.pos]) {
+		c := l.data[l.pos]
+		if c == '#' && l.pos+2 < len(l.data) {
+			if b, err := hex.DecodeString(string(l.data[l.pos+1 : l.pos+3])); err == nil {
+				name = append(name, b[0])
+				l.pos += 3
+				continue
+			}
+		}
+		name = append(name, c)
+		l.pos++
+	}
+	return pdfName(name)
+}
+
+// literal reads a string in parentheses
+func (l *pdfLexer) literal() pdfString {
+	l.pos++
+	var s []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return s
+			}
+		case '\r':
+			// end of line markers read as a newline
+			if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+				l.pos++
+			}
+			c = '\n'
+		case '\\':
+			if l.pos >= len(l.data) {
+				return s
+			}
+			c = l.data[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// line continuation
+				if c == '\r' && l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				n := int(c - '0')
+				for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+					n = n*8 + int(l.data[l.pos]-'0')
+					l.pos++
+				}
+				c = byte(n)
+			}
+		}
+		s = append(s, c)
+	}
+	return s
+}
+
+// hex reads a string of hexadecimal digits in angle brackets
+func (l *pdfLexer) hex() pdfString {
+	l.pos++
+	var s []byte
+	var b byte
+	odd := false
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		var v byte
+		switch {
+		case c == '>':
+			if odd {
+				s = append(s, b<<4)
+			}
+			return s
+		case c >= '0' && c <= '9':
+			v = c - '0'
+		case c >= 'a' && c <= 'f':
+			v = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			v = c - 'A' + 10
+		default:
+			continue
+		}
+		if odd {
+			s = append(s, b<<4|v)
+		} else {
+			b = v
+		}
+		odd = !odd
+	}
+	return s
+}
+
+// number reads an integer, a real number, or a reference "num gen R"
+func (l *pdfLexer) number() pdfObject {
+	start := l.pos
+	if c := l.data[l.pos]; c == '+' || c == '-' {
+		l.pos++
+	}
+	isReal := false
+	for ; l.pos < len(l.data); l.pos++ {
+		if c := l.data[l.pos]; c == '.' {
+			isReal = true
+		} else if c < '0' || c > '9' {
+			break
+		}
+	}
+	text := string(l.data[start:l.pos])
+	if isReal {
+		f, _ := strconv.ParseFloat(text, 64)
+		return f
+	}
+	n, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return float64(0)
+	}
+
+	mark := l.pos
+	if gen, ok := l.uint(); ok {
+		l.skipSpace()
+		if l.pos < len(l.data) && l.data[l.pos] == 'R' && (l.pos+1 == len(l.data) || isPDFSpace(l.data[l.pos+1]) || isPDFDelim(l.data[l.pos+1])) {
+			l.pos++
+			return pdfRef{int(n), gen}
+		}
+	}
+	l.pos = mark
+	return n
+}
+
+// uint reads an unsigned integer, such as an offset in a cross-reference
+// table
+func (l *pdfLexer) uint() (int, bool) {
+	l.skipSpace()
+	start := l.pos
+	for l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '9' {
+		l.pos++
+	}
+	if l.pos == start || l.pos < len(l.data) && l.data[l.pos] == '.' {
+		return 0, false
+	}
+	n, err := strconv.Atoi(string(l.data[start:l.pos]))
+	return n, err == nil
+}
+
+// keyword tells whether the next token is the given keyword, and reads it
+// if so
+func (l *pdfLexer) keyword(word string) bool {
+	l.skipSpace()
+	end := l.pos + len(word)
+	if end > len(l.data) || string(l.data[l.pos:end]) != word {
+		return false
+	}
+	if end < len(l.data) && !isPDFSpace(l.data[end]) && !isPDFDelim(l.data[end]) {
+		return false
+	}
+	l.pos = end
+	return true
+}
+
+func (l *pdfLexer) dict(depth int) (pdfObject, error) {
+	d := make(pdfDict)
+	for {
+		key, err := l.object(depth + 1)
+		if err != nil {
+			return d, err
+		}
+		if key == pdfOp(">>") {
+			return d, nil
+		}
+		name, ok := key.(pdfName)
+		if !ok {
+			continue
+		}
+		value, err := l.object(depth + 1)
+		if err != nil {
+			return d, err
+		}
+		if value == pdfOp(">>") {
+			return d, nil
+		}
+		d[name] = value
+	}
+}
+
+func (l *pdfLexer) array(depth int) (pdfObject, error) {
+	var a pdfArray
+	for {
+		obj, err := l.object(depth + 1)
+		if err != nil {
+			return a, err
+		}
+		if obj == pdfOp("]") {
+			return a, nil
+		}
+		a = append(a, obj)
+	}
+}
+
+// pdfXref locates an object: at an offset in the file, or in an object
+// stream
+type pdfXref struct {
+	offset     int
+	stream     int // object stream holding a compressed object
+	compressed bool
+}
+
+// pdfReader reads the objects of a PDF file held in memory
+type pdfReader struct {
+	data    []byte
+	xref    map[int]pdfXref
+	trailer pdfDict
+	objects map[int]pdfObject // objects read so far
+	loading map[int]bool      // objects being read, to break reference loops
+	objStms map[int]map[int]pdfObject
+	fonts   map[int]*pdfFont // fonts by object number
+}
+
+// newPDFReader reads the cross-reference data of a PDF file, rebuilding it
+// by scanning the file if it is damaged
+func newPDFReader(r io.ReaderAt, size int64) (*pdfReader, error) {
+	data, err := ioutil.ReadAll(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	if head := data[:minInt(len(data), 1024)]; !bytes.Contains(head, []byte("%PDF-")) {
+		return nil, docError("PDF", "missing %%PDF header")
+	}
+	p := &pdfReader{
+		data:    data,
+		xref:    make(map[int]pdfXref),
+		objects: make(map[int]pdfObject),
+		loading: make(map[int]bool),
+		objStms: make(map[int]map[int]pdfObject),
+		fonts:   make(map[int]*pdfFont),
+	}
+	// offsets that do not lead to the catalog are as damaged as missing ones
+	if !p.readXref() || p.dict(p.dict(p.trailer["Root"])["Pages"]) == nil {
+		p.repair()
+	}
+	if len(p.xref) == 0 {
+		return nil, docError("PDF", "no objects")
+	}
+	if p.trailer["Encrypt"] != nil {
+		return nil, docError("PDF", "encrypted")
+	}
+	return p, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// inFile tells whether an offset read from the file lies within it
+func (p *pdfReader) inFile(offset int64) bool {
+	return offset >= 0 && offset < int64(len(p.data))
+}
+
+// readXref reads the cross-reference sections from the last one back,
+// following /Prev, and tells whether they were all found
+func (p *pdfReader) readXref() bool {
+	tail := len(p.data) - 2048
+	if tail < 0 {
+		tail = 0
+	}
+	i := bytes.LastIndex(p.data[tail:], []byte("startxref"))
+	if i < 0 {
+		return false
+	}
+	l := &pdfLexer{p.data, tail + i + len("startxref")}
+	offset, ok := l.uint()
+	seen := make(map[int]bool)
+	for ok {
+		if seen[offset] || !p.inFile(int64(offset)) {
+			return false
+		}
+		seen[offset] = true
+		var prev pdfObject
+		if prev, ok = p.readXrefSection(offset); !ok {
+			return false
+		}
+		if prev == nil {
+			return true
+		}
+		var n int64
+		n, ok = prev.(int64)
+		if ok && !p.inFile(n) {
+			return false
+		}
+		offset = int(n)
+	}
+	return false
+}
+
+// readXrefSection reads a cross-reference table or stream at an offset and
+// returns the offset of the previous one, if any. Entries already known
+// come from newer sections and are kept.
+func (p *pdfReader) readXrefSection(offset int) (pdfObject, bool) {
+	l := &pdfLexer{p.data, offset}
+	if !l.keyword("xref") {
+		_, obj, ok := p.parseObjectAt(offset)
+		if !ok {
+			return nil, false
+		}
+		s, ok := obj.(*pdfStream)
+		if !ok || s.dict["Type"] != pdfName("XRef") {
+			return nil, false
+		}
+		if !p.readXrefStream(s) {
+			return nil, false
+		}
+		return s.dict["Prev"], true
+	}
+
+	for !l.keyword("trailer") {
+		start, ok := l.uint()
+		if !ok {
+			return nil, false
+		}
+		count, ok := l.uint()
+		if !ok {
+			return nil, false
+		}
+		for i := 0; i < count; i++ {
+			off, ok1 := l.uint()
+			_, ok2 := l.uint()
+			kind, _ := l.object(0)
+			if !ok1 || !ok2 {
+				return nil, false
+			}
+			if _, known := p.xref[start+i]; !known && kind == pdfOp("n") && p.inFile(int64(off)) {
+				p.xref[start+i] = pdfXref{offset: off}
+			}
+		}
+	}
+	obj, err := l.object(0)
+	trailer, ok := obj.(pdfDict)
+	if err != nil || !ok {
+		return nil, false
+	}
+	if p.trailer == nil {
+		p.trailer = trailer
+	}
+	// a hybrid file lists its compressed objects in a stream as well
+	if off, ok := trailer["XRefStm"].(int64); ok && p.inFile(off) {
+		if _, obj, ok := p.parseObjectAt(int(off)); ok {
+			if s, ok := obj.(*pdfStream); ok {
+				p.readXrefStream(s)
+			}
+		}
+	}
+	return trailer["Prev"], true
+}
+
+// readXrefStream reads the entries of a cross-reference stream
+func (p *pdfReader) readXrefStream(s *pdfStream) bool {
+	if p.trailer == nil {
+		p.trailer = s.dict
+	}
+	data, err := p.decode(s)
+	if err != nil {
+		return false
+	}
+	widths, _ := s.dict["W"].(pdfArray)
+	if len(widths) != 3 {
+		return false
+	}
+	var w [3]int
+	for i, x := range widths {
+		n, ok := x.(int64)
+		if !ok || n < 0 || n > 8 {
+			return false
+		}
+		w[i] = int(n)
+	}
+	index, _ := s.dict["Index"].(pdfArray)
+	if index == nil {
+		index = pdfArray{int64(0), s.dict["Size"]}
+	}
+	entry := w[0] + w[1] + w[2]
+	if entry == 0 {
+		return false
+	}
+	// fields are up to 8 bytes wide, too wide for an int to hold them all
+	field := func(b []byte) uint64 {
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n
+	}
+	for i := 0; i+1 < len(index); i += 2 {
+		start, ok1 := index[i].(int64)
+		count, ok2 := index[i+1].(int64)
+		if !ok1 || !ok2 {
+			return false
+		}
+		for j := 0; j < int(count) && len(data) >= entry; j++ {
+			kind := uint64(1)
+			if w[0] > 0 {
+				kind = field(data[:w[0]])
+			}
+			f2 := field(data[w[0] : w[0]+w[1]])
+			data = data[entry:]
+			num := int(start) + j
+			if _, known := p.xref[num]; known {
+				continue
+			}
+			switch {
+			case kind == 1 && f2 < uint64(len(p.data)):
+				p.xref[num] = pdfXref{offset: int(f2)}
+			case kind == 2 && f2 <= math.MaxInt32:
+				p.xref[num] = pdfXref{stream: int(f2), compressed: true}
+			}
+		}
+	}
+	return true
+}
+
+var pdfObjHeader = regexp.MustCompile(`(\d+)[\x00\t\n\f\r ]+(\d+)[\x00\t\n\f\r ]+obj\b`)
+
+// repair rebuilds the cross-reference data by scanning the file for
+// objects, later copies replacing earlier ones as incremental updates do
+func (p *pdfReader) repair() {
+	p.xref = make(map[int]pdfXref)
+	p.objects = make(map[int]pdfObject)
+	p.trailer = nil
+	for _, m := range pdfObjHeader.FindAllSubmatchIndex(p.data, -1) {
+		if m[0] > 0 && !isPDFSpace(p.data[m[0]-1]) && !isPDFDelim(p.data[m[0]-1]) {
+			continue
+		}
+		num, _ := strconv.Atoi(string(p.data[m[2]:m[3]]))
+		p.xref[num] = pdfXref{offset: m[0]}
+	}
+
+	for i := bytes.Index(p.data, []byte("trailer")); i >= 0; {
+		l := &pdfLexer{p.data, i + len("trailer")}
+		if obj, err := l.object(0); err == nil {
+			if d, ok := obj.(pdfDict); ok && d["Root"] != nil {
+				p.trailer = d
+			}
+		}
+		next := bytes.Index(p.data[i+1:], []byte("trailer"))
+		if next < 0 {
+			break
+		}
+		i += 1 + next
+	}
+
+	// objects in object streams
+	nums := make([]int, 0, len(p.xref))
+	for num := range p.xref {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	for _, num := range nums {
+		if s, ok := p.object(num).(*pdfStream); ok && s.dict["Type"] == pdfName("ObjStm") {
+			for n := range p.objStm(num) {
+				if _, known := p.xref[n]; !known {
+					p.xref[n] = pdfXref{stream: num, compressed: true}
+					nums = append(nums, n)
+				}
+			}
+		}
+	}
+
+	// without a trailer, the catalog is found by its type
+	for _, num := range nums {
+		if p.trailer != nil {
+			break
+		}
+		switch obj := p.object(num).(type) {
+		case *pdfStream:
+			if obj.dict["Type"] == pdfName("XRef") && obj.dict["Root"] != nil {
+				p.trailer = obj.dict
+			}
+		case pdfDict:
+			if obj["Type"] == pdfName("Catalog") {
+				p.trailer = pdfDict{"Root": pdfRef{num, 0}}
+			}
+		}
+	}
+}
+
+// parseObjectAt reads the indirect object "num gen obj ... endobj" at an
+// offset
+func (p *pdfReader) parseObjectAt(offset int) (int, pdfObject, bool) {
+	if !p.inFile(int64(offset)) {
+		return 0, nil, false
+	}
+	l := &pdfLexer{p.data, offset}
+	num, ok := l.uint()
+	if !ok {
+		return 0, nil, false
+	}
+	if _, ok := l.uint(); !ok || !l.keyword("obj") {
+		return 0, nil, false
+	}
+	obj, err := l.object(0)
+	if err != nil && err != errPDFEnd {
+		return 0, nil, false
+	}
+	d, ok := obj.(pdfDict)
+	if !ok || !l.keyword("stream") {
+		return num, obj, true
+	}
+
+	// stream data starts after the end of line following the keyword
+	if l.pos < len(p.data) && p.data[l.pos] == '\r' {
+		l.pos++
+	}
+	if l.pos < len(p.data) && p.data[l.pos] == '\n' {
+		l.pos++
+	}
+	start := l.pos
+	if n, ok := p.resolve(d["Length"]).(int64); ok && n >= 0 && n <= int64(len(p.data)-start) {
+		end := &pdfLexer{p.data, start + int(n)}
+		if end.keyword("endstream") {
+			return num, &pdfStream{d, p.data[start : start+int(n)]}, true
+		}
+	}
+	// a missing or wrong length: the data runs up to endstream
+	end := bytes.Index(p.data[start:], []byte("endstream"))
+	if end < 0 {
+		end = len(p.data) - start
+	}
+	data := p.data[start : start+end]
+	data = bytes.TrimSuffix(data, []byte("\n"))
+	data = bytes.TrimSuffix(data, []byte("\r"))
+	return num, &pdfStream{d, data}, true
+}
+
+// object returns an indirect object by number, or nil if it does not exist
+func (p *pdfReader) object(num int) pdfObject {
+	if obj, ok := p.objects[num]; ok {
+		return obj
+	}
+	e, ok := p.xref[num]
+	if !ok || p.loading[num] {
+		return nil
+	}
+	p.loading[num] = true
+	defer delete(p.loading, num)
+
+	var obj pdfObject
+	if e.compressed {
+		obj = p.objStm(e.stream)[num]
+	} else if n, o, ok := p.parseObjectAt(e.offset); ok && n == num {
+		obj = o
+	}
+	p.objects[num] = obj
+	return obj
+}
+
+// objStm returns the objects held in an object stream
+func (p *pdfReader) objStm(num int) map[int]pdfObject {
+	if objs, ok := p.objStms[num]; ok {
+		return objs
+	}
+	objs := make(map[int]pdfObject)
+	p.objStms[num] = objs
+	s, ok := p.object(num).(*pdfStream)
+	if !ok {
+		return objs
+	}
+	data, err := p.decode(s)
+	if err != nil {
+		return objs
+	}
+	n, _ := p.resolve(s.dict["N"]).(int64)
+	first, _ := p.resolve(s.dict["First"]).(int64)
+	if first < 0 || int(first) > len(data) {
+		return objs
+	}
+	header := &pdfLexer{data[:first], 0}
+	for i := int64(0); i < n; i++ {
+		obj, ok1 := header.uint()
+		off, ok2 := header.uint()
+		if !ok1 || !ok2 || off > len(data)-int(first) {
+			break
+		}
+		l := &pdfLexer{data, int(first) + off}
+		if o, err := l.object(0); err == nil {
+			objs[obj] = o
+		}
+	}
+	return objs
+}
+
+// resolve follows references to the object they refer to
+func (p *pdfReader) resolve(obj pdfObject) pdfObject {
+	for i := 0; i < 32; i++ {
+		ref, ok := obj.(pdfRef)
+		if !ok {
+			return obj
+		}
+		obj = p.object(ref.num)
+	}
+	return nil
+}
+
+// dict resolves an object expected to be a dictionary, or a stream whose
+// dictionary is returned
+func (p *pdfReader) dict(obj pdfObject) pdfDict {
+	switch obj := p.resolve(obj).(type) {
+	case pdfDict:
+		return obj
+	case *pdfStream:
+		return obj.dict
+	}
+	return nil
+}
+
+// decode returns the data of a stream with its filters undone
+func (p *pdfReader) decode(s *pdfStream) ([]byte, error) {
+	var filters, params pdfArray
+	switch f := p.resolve(s.dict["Filter"]).(type) {
+	case pdfName:
+		filters = pdfArray{f}
+	case pdfArray:
+		filters = f
+	}
+	switch d := p.resolve(s.dict["DecodeParms"]).(type) {
+	case pdfDict:
+		params = pdfArray{d}
+	case pdfArray:
+		params = d
+	}
+
+	data := s.data
+	for i, f := range filters {
+		var err error
+		switch p.resolve(f) {
+		case pdfName("FlateDecode"), pdfName("Fl"):
+			if data, err = inflate(data); err != nil {
+				return nil, err
+			}
+			if i < len(params) {
+				data, err = unpredict(data, p.dict(params[i]))
+			}
+		case pdfName("ASCIIHexDecode"), pdfName("AHx"):
+			data = (&pdfLexer{append([]byte{'<'}, data...), 0}).hex()
+		case pdfName("ASCII85Decode"), pdfName("A85"):
+			data, err = decodeASCII85(data)
+		default:
+			return nil, docError("PDF", "unsupported filter %v", f)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// inflate undoes FlateDecode, keeping what could be read of damaged data
+func inflate(data []byte) ([]byte, error) {
+	var r io.Reader
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		r = flate.NewReader(bytes.NewReader(data))
+	} else {
+		r = zr
+	}
+	out, err := ioutil.ReadAll(io.LimitReader(r, maxPDFStream))
+	if err != nil && len(out) == 0 {
+		return nil, docError("PDF", "bad compressed stream: %v", err)
+	}
+	return out, nil
+}
+
+// unpredict undoes the PNG predictors applied before compression, as in
+// cross-reference streams
+func unpredict(data []byte, params pdfDict) ([]byte, error) {
+	predictor, _ := params["Predictor"].(int64)
+	if predictor < 10 {
+		return data, nil
+	}
+	colors, bits, columns := int64(1), int64(8), int64(1)
+	if n, ok := params["Colors"].(int64); ok {
+		colors = n
+	}
+	if n, ok := params["BitsPerComponent"].(int64); ok {
+		bits = n
+	}
+	if n, ok := params["Columns"].(int64); ok {
+		columns = n
+	}
+	if colors < 1 || bits < 1 || columns < 1 || colors*bits*columns > 1<<20 {
+		return nil, docError("PDF", "bad predictor parameters")
+	}
+	bpp := int((colors*bits + 7) / 8)
+	row := int((colors*bits*columns + 7) / 8)
+
+	var out []byte
+	prev := make([]byte, row)
+	for len(data) > row {
+		filter, cur := data[0], data[1:row+1]
+		data = data[row+1:]
+		for i := range cur {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = cur[i-bpp], prev[i-bpp]
+			}
+			up := prev[i]
+			switch filter {
+			case 1:
+				cur[i] += left
+			case 2:
+				cur[i] += up
+			case 3:
+				cur[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				cur[i] += paeth(left, up, upLeft)
+			}
+		}
+		out = append(out, cur...)
+		prev = cur
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := absInt(p-int(a)), absInt(p-int(b)), absInt(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// decodeASCII85 undoes ASCII85Decode
+func decodeASCII85(data []byte) ([]byte, error) {
+	var out []byte
+	var group [5]byte
+	n := 0
+	for _, c := range data {
+		if c == '~' {
+			break
+		}
+		switch {
+		case c == 'z' && n == 0:
+			out = append(out, 0, 0, 0, 0)
+		case c >= '!' && c <= 'u':
+			group[n] = c - '!'
+			n++
+			if n == 5 {
+				out = appendBase85(out, group, 5)
+				n = 0
+			}
+		}
+	}
+	if n == 1 {
+		return nil, docError("PDF", "bad ASCII85 data")
+	}
+	if n > 0 {
+		for i := n; i < 5; i++ {
+			group[i] = 'u' - '!'
+		}
+		out = appendBase85(out, group, n)
+	}
+	return out, nil
+}
+
+// appendBase85 appends the n-1 bytes encoded by a group of base 85 digits
+func appendBase85(out []byte, group [5]byte, n int) []byte {
+	var v uint32
+	for _, d := range group {
+		v = v*85 + uint32(d)
+	}
+	b := []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+	return append(out, b[:n-1]...)
+}
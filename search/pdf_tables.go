@@ -0,0 +1,475 @@
+package search
+
+// Encodings from the PDF 1.7 reference, appendix D, and glyph names from
+// the Adobe Glyph List. Unassigned codes map to 0.
+
+// standardEncoding is the StandardEncoding of Type 1 fonts
+var standardEncoding = [256]rune{
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x00
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x08
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x10
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x18
+	0x0020, 0x0021, 0x0022, 0x0023, 0x0024, 0x0025, 0x0026, 0x2019, // 0x20
+	0x0028, 0x0029, 0x002a, 0x002b, 0x002c, 0x002d, 0x002e, 0x002f, // 0x28
+	0x0030, 0x0031, 0x0032, 0x0033, 0x0034, 0x0035, 0x0036, 0x0037, // 0x30
+	0x0038, 0x0039, 0x003a, 0x003b, 0x003c, 0x003d, 0x003e, 0x003f, // 0x38
+	0x0040, 0x0041, 0x0042, 0x0043, 0x0044, 0x0045, 0x0046, 0x0047, // 0x40
+	0x0048, 0x0049, 0x004a, 0x004b, 0x004c, 0x004d, 0x004e, 0x004f, // 0x48
+	0x0050, 0x0051, 0x0052, 0x0053, 0x0054, 0x0055, 0x0056, 0x0057, // 0x50
+	0x0058, 0x0059, 0x005a, 0x005b, 0x005c, 0x005d, 0x005e, 0x005f, // 0x58
+	0x2018, 0x0061, 0x0062, 0x0063, 0x0064, 0x0065, 0x0066, 0x0067, // 0x60
+	0x0068, 0x0069, 0x006a, 0x006b, 0x006c, 0x006d, 0x006e, 0x006f, // 0x68
+	0x0070, 0x0071, 0x0072, 0x0073, 0x0074, 0x0075, 0x0076, 0x0077, // 0x70
+	0x0078, 0x0079, 0x007a, 0x007b, 0x007c, 0x007d, 0x007e, 0x0000, // 0x78
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x80
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x88
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x90
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x98
+	0x0000, 0x00a1, 0x00a2, 0x00a3, 0x2044, 0x00a5, 0x0192, 0x00a7, // 0xa0
+	0x00a4, 0x0027, 0x201c, 0x00ab, 0x2039, 0x203a, 0xfb01, 0xfb02, // 0xa8
+	0x0000, 0x2013, 0x2020, 0x2021, 0x00b7, 0x0000, 0x00b6, 0x2022, // 0xb0
+	0x201a, 0x201e, 0x201d, 0x00bb, 0x2026, 0x2030, 0x0000, 0x00bf, // 0xb8
+	0x0000, 0x0060, 0x00b4, 0x02c6, 0x02dc, 0x00af, 0x02d8, 0x02d9, // 0xc0
+	0x00a8, 0x0000, 0x02da, 0x00b8, 0x0000, 0x02dd, 0x02db, 0x02c7, // 0xc8
+	0x2014, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0xd0
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0xd8
+	0x0000, 0x00c6, 0x0000, 0x00aa, 0x0000, 0x0000, 0x0000, 0x0000, // 0xe0
+	0x0141, 0x00d8, 0x0152, 0x00ba, 0x0000, 0x0000, 0x0000, 0x0000, // 0xe8
+	0x0000, 0x00e6, 0x0000, 0x0000, 0x0000, 0x0131, 0x0000, 0x0000, // 0xf0
+	0x0142, 0x00f8, 0x0153, 0x00df, 0x0000, 0x0000, 0x0000, 0x0000, // 0xf8
+}
+
+// winAnsiEncoding is WinAnsiEncoding, Windows code page 1252
+var winAnsiEncoding = [256]rune{
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x00
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x08
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x10
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x18
+	0x0020, 0x0021, 0x0022, 0x0023, 0x0024, 0x0025, 0x0026, 0x0027, // 0x20
+	0x0028, 0x0029, 0x002a, 0x002b, 0x002c, 0x002d, 0x002e, 0x002f, // 0x28
+	0x0030, 0x0031, 0x0032, 0x0033, 0x0034, 0x0035, 0x0036, 0x0037, // 0x30
+	0x0038, 0x0039, 0x003a, 0x003b, 0x003c, 0x003d, 0x003e, 0x003f, // 0x38
+	0x0040, 0x0041, 0x0042, 0x0043, 0x0044, 0x0045, 0x0046, 0x0047, // 0x40
+	0x0048, 0x0049, 0x004a, 0x004b, 0x004c, 0x004d, 0x004e, 0x004f, // 0x48
+	0x0050, 0x0051, 0x0052, 0x0053, 0x0054, 0x0055, 0x0056, 0x0057, // 0x50
+	0x0058, 0x0059, 0x005a, 0x005b, 0x005c, 0x005d, 0x005e, 0x005f, // 0x58
+	0x0060, 0x0061, 0x0062, 0x0063, 0x0064, 0x0065, 0x0066, 0x0067, // 0x60
+	0x0068, 0x0069, 0x006a, 0x006b, 0x006c, 0x006d, 0x006e, 0x006f, // 0x68
+	0x0070, 0x0071, 0x0072, 0x0073, 0x0074, 0x0075, 0x0076, 0x0077, // 0x70
+	0x0078, 0x0079, 0x007a, 0x007b, 0x007c, 0x007d, 0x007e, 0x0000, // 0x78
+	0x20ac, 0x0000, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021, // 0x80
+	0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0x0000, 0x017d, 0x0000, // 0x88
+	0x0000, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014, // 0x90
+	0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0x0000, 0x017e, 0x0178, // 0x98
+	0x00a0, 0x00a1, 0x00a2, 0x00a3, 0x00a4, 0x00a5, 0x00a6, 0x00a7, // 0xa0
+	0x00a8, 0x00a9, 0x00aa, 0x00ab, 0x00ac, 0x00ad, 0x00ae, 0x00af, // 0xa8
+	0x00b0, 0x00b1, 0x00b2, 0x00b3, 0x00b4, 0x00b5, 0x00b6, 0x00b7, // 0xb0
+	0x00b8, 0x00b9, 0x00ba, 0x00bb, 0x00bc, 0x00bd, 0x00be, 0x00bf, // 0xb8
+	0x00c0, 0x00c1, 0x00c2, 0x00c3, 0x00c4, 0x00c5, 0x00c6, 0x00c7, // 0xc0
+	0x00c8, 0x00c9, 0x00ca, 0x00cb, 0x00cc, 0x00cd, 0x00ce, 0x00cf, // 0xc8
+	0x00d0, 0x00d1, 0x00d2, 0x00d3, 0x00d4, 0x00d5, 0x00d6, 0x00d7, // 0xd0
+	0x00d8, 0x00d9, 0x00da, 0x00db, 0x00dc, 0x00dd, 0x00de, 0x00df, // 0xd8
+	0x00e0, 0x00e1, 0x00e2, 0x00e3, 0x00e4, 0x00e5, 0x00e6, 0x00e7, // 0xe0
+	0x00e8, 0x00e9, 0x00ea, 0x00eb, 0x00ec, 0x00ed, 0x00ee, 0x00ef, // 0xe8
+	0x00f0, 0x00f1, 0x00f2, 0x00f3, 0x00f4, 0x00f5, 0x00f6, 0x00f7, // 0xf0
+	0x00f8, 0x00f9, 0x00fa, 0x00fb, 0x00fc, 0x00fd, 0x00fe, 0x00ff, // 0xf8
+}
+
+// macRomanEncoding is MacRomanEncoding
+var macRomanEncoding = [256]rune{
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x00
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x08
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x10
+	0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, 0x0000, // 0x18
+	0x0020, 0x0021, 0x0022, 0x0023, 0x0024, 0x0025, 0x0026, 0x0027, // 0x20
+	0x0028, 0x0029, 0x002a, 0x002b, 0x002c, 0x002d, 0x002e, 0x002f, // 0x28
+	0x0030, 0x0031, 0x0032, 0x0033, 0x0034, 0x0035, 0x0036, 0x0037, // 0x30
+	0x0038, 0x0039, 0x003a, 0x003b, 0x003c, 0x003d, 0x003e, 0x003f, // 0x38
+	0x0040, 0x0041, 0x0042, 0x0043, 0x0044, 0x0045, 0x0046, 0x0047, // 0x40
+	0x0048, 0x0049, 0x004a, 0x004b, 0x004c, 0x004d, 0x004e, 0x004f, // 0x48
+	0x0050, 0x0051, 0x0052, 0x0053, 0x0054, 0x0055, 0x0056, 0x0057, // 0x50
+	0x0058, 0x0059, 0x005a, 0x005b, 0x005c, 0x005d, 0x005e, 0x005f, // 0x58
+	0x0060, 0x0061, 0x0062, 0x0063, 0x0064, 0x0065, 0x0066, 0x0067, // 0x60
+	0x0068, 0x0069, 0x006a, 0x006b, 0x006c, 0x006d, 0x006e, 0x006f, // 0x68
+	0x0070, 0x0071, 0x0072, 0x0073, 0x0074, 0x0075, 0x0076, 0x0077, // 0x70
+	0x0078, 0x0079, 0x007a, 0x007b, 0x007c, 0x007d, 0x007e, 0x0000, // 0x78
+	0x00c4, 0x00c5, 0x00c7, 0x00c9, 0x00d1, 0x00d6, 0x00dc, 0x00e1, // 0x80
+	0x00e0, 0x00e2, 0x00e4, 0x00e3, 0x00e5, 0x00e7, 0x00e9, 0x00e8, // 0x88
+	0x00ea, 0x00eb, 0x00ed, 0x00ec, 0x00ee, 0x00ef, 0x00f1, 0x00f3, // 0x90
+	0x00f2, 0x00f4, 0x00f6, 0x00f5, 0x00fa, 0x00f9, 0x00fb, 0x00fc, // 0x98
+	0x2020, 0x00b0, 0x00a2, 0x00a3, 0x00a7, 0x2022, 0x00b6, 0x00df, // 0xa0
+	0x00ae, 0x00a9, 0x2122, 0x00b4, 0x00a8, 0x2260, 0x00c6, 0x00d8, // 0xa8
+	0x221e, 0x00b1, 0x2264, 0x2265, 0x00a5, 0x00b5, 0x2202, 0x2211, // 0xb0
+	0x220f, 0x03c0, 0x222b, 0x00aa, 0x00ba, 0x03a9, 0x00e6, 0x00f8, // 0xb8
+	0x00bf, 0x00a1, 0x00ac, 0x221a, 0x0192, 0x2248, 0x2206, 0x00ab, // 0xc0
+	0x00bb, 0x2026, 0x00a0, 0x00c0, 0x00c3, 0x00d5, 0x0152, 0x0153, // 0xc8
+	0x2013, 0x2014, 0x201c, 0x201d, 0x2018, 0x2019, 0x00f7, 0x25ca, // 0xd0
+	0x00ff, 0x0178, 0x2044, 0x20ac, 0x2039, 0x203a, 0xfb01, 0xfb02, // 0xd8
+	0x2021, 0x00b7, 0x201a, 0x201e, 0x2030, 0x00c2, 0x00ca, 0x00c1, // 0xe0
+	0x00cb, 0x00c8, 0x00cd, 0x00ce, 0x00cf, 0x00cc, 0x00d3, 0x00d4, // 0xe8
+	0xf8ff, 0x00d2, 0x00da, 0x00db, 0x00d9, 0x0131, 0x02c6, 0x02dc, // 0xf0
+	0x00af, 0x02d8, 0x02d9, 0x02da, 0x00b8, 0x02dd, 0x02db, 0x02c7, // 0xf8
+}
+
+// glyphNames maps the glyph names used in font encodings to runes
+var glyphNames = map[string]rune{
+	"A":              0x0041,
+	"AE":             0x00c6,
+	"Aacute":         0x00c1,
+	"Abreve":         0x0102,
+	"Acircumflex":    0x00c2,
+	"Adieresis":      0x00c4,
+	"Agrave":         0x00c0,
+	"Amacron":        0x0100,
+	"Aogonek":        0x0104,
+	"Aring":          0x00c5,
+	"Atilde":         0x00c3,
+	"B":              0x0042,
+	"C":              0x0043,
+	"Cacute":         0x0106,
+	"Ccaron":         0x010c,
+	"Ccedilla":       0x00c7,
+	"Ccircumflex":    0x0108,
+	"Cdotaccent":     0x010a,
+	"D":              0x0044,
+	"Dcaron":         0x010e,
+	"Delta":          0x2206,
+	"Dslash":         0x0110,
+	"E":              0x0045,
+	"Eacute":         0x00c9,
+	"Ebreve":         0x0114,
+	"Ecaron":         0x011a,
+	"Ecircumflex":    0x00ca,
+	"Edieresis":      0x00cb,
+	"Edotaccent":     0x0116,
+	"Egrave":         0x00c8,
+	"Emacron":        0x0112,
+	"Eogonek":        0x0118,
+	"Eth":            0x00d0,
+	"Euro":           0x20ac,
+	"F":              0x0046,
+	"G":              0x0047,
+	"Gbreve":         0x011e,
+	"Gcedilla":       0x0122,
+	"Gcircumflex":    0x011c,
+	"Gdotaccent":     0x0120,
+	"H":              0x0048,
+	"Hcircumflex":    0x0124,
+	"Hslash":         0x0126,
+	"I":              0x0049,
+	"Iacute":         0x00cd,
+	"Ibreve":         0x012c,
+	"Icircumflex":    0x00ce,
+	"Idieresis":      0x00cf,
+	"Idotaccent":     0x0130,
+	"Igrave":         0x00cc,
+	"Imacron":        0x012a,
+	"Iogonek":        0x012e,
+	"Itilde":         0x0128,
+	"J":              0x004a,
+	"Jcircumflex":    0x0134,
+	"K":              0x004b,
+	"Kcedilla":       0x0136,
+	"L":              0x004c,
+	"Lacute":         0x0139,
+	"Lcaron":         0x013d,
+	"Lcedilla":       0x013b,
+	"Lslash":         0x0141,
+	"M":              0x004d,
+	"N":              0x004e,
+	"Nacute":         0x0143,
+	"Ncaron":         0x0147,
+	"Ncedilla":       0x0145,
+	"Ntilde":         0x00d1,
+	"O":              0x004f,
+	"OE":             0x0152,
+	"Oacute":         0x00d3,
+	"Obreve":         0x014e,
+	"Ocircumflex":    0x00d4,
+	"Odieresis":      0x00d6,
+	"Ograve":         0x00d2,
+	"Ohungarumlaut":  0x0150,
+	"Omacron":        0x014c,
+	"Omega":          0x03a9,
+	"Oslash":         0x00d8,
+	"Otilde":         0x00d5,
+	"P":              0x0050,
+	"Q":              0x0051,
+	"R":              0x0052,
+	"Racute":         0x0154,
+	"Rcaron":         0x0158,
+	"Rcedilla":       0x0156,
+	"S":              0x0053,
+	"Sacute":         0x015a,
+	"Scaron":         0x0160,
+	"Scedilla":       0x015e,
+	"Scircumflex":    0x015c,
+	"T":              0x0054,
+	"Tcaron":         0x0164,
+	"Tcedilla":       0x0162,
+	"Thorn":          0x00de,
+	"Tslash":         0x0166,
+	"U":              0x0055,
+	"Uacute":         0x00da,
+	"Ubreve":         0x016c,
+	"Ucircumflex":    0x00db,
+	"Udieresis":      0x00dc,
+	"Ugrave":         0x00d9,
+	"Uhungarumlaut":  0x0170,
+	"Umacron":        0x016a,
+	"Uogonek":        0x0172,
+	"Uring":          0x016e,
+	"Utilde":         0x0168,
+	"V":              0x0056,
+	"W":              0x0057,
+	"Wcircumflex":    0x0174,
+	"X":              0x0058,
+	"Y":              0x0059,
+	"Yacute":         0x00dd,
+	"Ycircumflex":    0x0176,
+	"Ydieresis":      0x0178,
+	"Z":              0x005a,
+	"Zacute":         0x0179,
+	"Zcaron":         0x017d,
+	"Zdotaccent":     0x017b,
+	"a":              0x0061,
+	"aacute":         0x00e1,
+	"abreve":         0x0103,
+	"acircumflex":    0x00e2,
+	"acute":          0x00b4,
+	"adieresis":      0x00e4,
+	"ae":             0x00e6,
+	"agrave":         0x00e0,
+	"amacron":        0x0101,
+	"ampersand":      0x0026,
+	"aogonek":        0x0105,
+	"apple":          0xf8ff,
+	"approxequal":    0x2248,
+	"aring":          0x00e5,
+	"asciicircum":    0x005e,
+	"asciitilde":     0x007e,
+	"asterisk":       0x002a,
+	"at":             0x0040,
+	"atilde":         0x00e3,
+	"b":              0x0062,
+	"backslash":      0x005c,
+	"bar":            0x007c,
+	"braceleft":      0x007b,
+	"braceright":     0x007d,
+	"bracketleft":    0x005b,
+	"bracketright":   0x005d,
+	"breve":          0x02d8,
+	"brokenbar":      0x00a6,
+	"bullet":         0x2022,
+	"c":              0x0063,
+	"cacute":         0x0107,
+	"caron":          0x02c7,
+	"ccaron":         0x010d,
+	"ccedilla":       0x00e7,
+	"ccircumflex":    0x0109,
+	"cdotaccent":     0x010b,
+	"cedilla":        0x00b8,
+	"cent":           0x00a2,
+	"circumflex":     0x02c6,
+	"colon":          0x003a,
+	"comma":          0x002c,
+	"copyright":      0x00a9,
+	"currency":       0x00a4,
+	"d":              0x0064,
+	"dagger":         0x2020,
+	"daggerdbl":      0x2021,
+	"dcaron":         0x010f,
+	"degree":         0x00b0,
+	"dieresis":       0x00a8,
+	"divide":         0x00f7,
+	"dollar":         0x0024,
+	"dotaccent":      0x02d9,
+	"dotlessi":       0x0131,
+	"dotlessj":       0x0237,
+	"dslash":         0x0111,
+	"e":              0x0065,
+	"eacute":         0x00e9,
+	"ebreve":         0x0115,
+	"ecaron":         0x011b,
+	"ecircumflex":    0x00ea,
+	"edieresis":      0x00eb,
+	"edotaccent":     0x0117,
+	"egrave":         0x00e8,
+	"eight":          0x0038,
+	"ellipsis":       0x2026,
+	"emacron":        0x0113,
+	"emdash":         0x2014,
+	"endash":         0x2013,
+	"eogonek":        0x0119,
+	"equal":          0x003d,
+	"eth":            0x00f0,
+	"exclam":         0x0021,
+	"exclamdown":     0x00a1,
+	"f":              0x0066,
+	"ff":             0xfb00,
+	"ffi":            0xfb03,
+	"ffl":            0xfb04,
+	"fi":             0xfb01,
+	"five":           0x0035,
+	"fl":             0xfb02,
+	"florin":         0x0192,
+	"four":           0x0034,
+	"fraction":       0x2044,
+	"g":              0x0067,
+	"gbreve":         0x011f,
+	"gcedilla":       0x0123,
+	"gcircumflex":    0x011d,
+	"gdotaccent":     0x0121,
+	"germandbls":     0x00df,
+	"grave":          0x0060,
+	"greater":        0x003e,
+	"greaterequal":   0x2265,
+	"guillemotleft":  0x00ab,
+	"guillemotright": 0x00bb,
+	"guilsinglleft":  0x2039,
+	"guilsinglright": 0x203a,
+	"h":              0x0068,
+	"hcircumflex":    0x0125,
+	"hslash":         0x0127,
+	"hungarumlaut":   0x02dd,
+	"hyphen":         0x002d,
+	"i":              0x0069,
+	"iacute":         0x00ed,
+	"ibreve":         0x012d,
+	"icircumflex":    0x00ee,
+	"idieresis":      0x00ef,
+	"igrave":         0x00ec,
+	"imacron":        0x012b,
+	"infinity":       0x221e,
+	"integral":       0x222b,
+	"iogonek":        0x012f,
+	"itilde":         0x0129,
+	"j":              0x006a,
+	"jcircumflex":    0x0135,
+	"k":              0x006b,
+	"kcedilla":       0x0137,
+	"l":              0x006c,
+	"lacute":         0x013a,
+	"lcaron":         0x013e,
+	"lcedilla":       0x013c,
+	"less":           0x003c,
+	"lessequal":      0x2264,
+	"logicalnot":     0x00ac,
+	"lozenge":        0x25ca,
+	"lslash":         0x0142,
+	"m":              0x006d,
+	"macron":         0x00af,
+	"minus":          0x2212,
+	"mu":             0x00b5,
+	"multiply":       0x00d7,
+	"n":              0x006e,
+	"nacute":         0x0144,
+	"nbspace":        0x00a0,
+	"ncaron":         0x0148,
+	"ncedilla":       0x0146,
+	"nine":           0x0039,
+	"notequal":       0x2260,
+	"ntilde":         0x00f1,
+	"numbersign":     0x0023,
+	"o":              0x006f,
+	"oacute":         0x00f3,
+	"obreve":         0x014f,
+	"ocircumflex":    0x00f4,
+	"odieresis":      0x00f6,
+	"oe":             0x0153,
+	"ogonek":         0x02db,
+	"ograve":         0x00f2,
+	"ohungarumlaut":  0x0151,
+	"omacron":        0x014d,
+	"one":            0x0031,
+	"onehalf":        0x00bd,
+	"onequarter":     0x00bc,
+	"onesuperior":    0x00b9,
+	"ordfeminine":    0x00aa,
+	"ordmasculine":   0x00ba,
+	"oslash":         0x00f8,
+	"otilde":         0x00f5,
+	"p":              0x0070,
+	"paragraph":      0x00b6,
+	"parenleft":      0x0028,
+	"parenright":     0x0029,
+	"partialdiff":    0x2202,
+	"percent":        0x0025,
+	"period":         0x002e,
+	"periodcentered": 0x00b7,
+	"perthousand":    0x2030,
+	"pi":             0x03c0,
+	"plus":           0x002b,
+	"plusminus":      0x00b1,
+	"product":        0x220f,
+	"q":              0x0071,
+	"question":       0x003f,
+	"questiondown":   0x00bf,
+	"quotedbl":       0x0022,
+	"quotedblbase":   0x201e,
+	"quotedblleft":   0x201c,
+	"quotedblright":  0x201d,
+	"quoteleft":      0x2018,
+	"quoteright":     0x2019,
+	"quotesinglbase": 0x201a,
+	"quotesingle":    0x0027,
+	"r":              0x0072,
+	"racute":         0x0155,
+	"radical":        0x221a,
+	"rcaron":         0x0159,
+	"rcedilla":       0x0157,
+	"registered":     0x00ae,
+	"ring":           0x02da,
+	"s":              0x0073,
+	"sacute":         0x015b,
+	"scaron":         0x0161,
+	"scedilla":       0x015f,
+	"scircumflex":    0x015d,
+	"section":        0x00a7,
+	"semicolon":      0x003b,
+	"seven":          0x0037,
+	"sfthyphen":      0x00ad,
+	"six":            0x0036,
+	"slash":          0x002f,
+	"space":          0x0020,
+	"sterling":       0x00a3,
+	"summation":      0x2211,
+	"t":              0x0074,
+	"tcaron":         0x0165,
+	"tcedilla":       0x0163,
+	"thorn":          0x00fe,
+	"three":          0x0033,
+	"threequarters":  0x00be,
+	"threesuperior":  0x00b3,
+	"tilde":          0x02dc,
+	"trademark":      0x2122,
+	"tslash":         0x0167,
+	"two":            0x0032,
+	"twosuperior":    0x00b2,
+	"u":              0x0075,
+	"uacute":         0x00fa,
+	"ubreve":         0x016d,
+	"ucircumflex":    0x00fb,
+	"udieresis":      0x00fc,
+	"ugrave":         0x00f9,
+	"uhungarumlaut":  0x0171,
+	"umacron":        0x016b,
+	"underscore":     0x005f,
+	"uogonek":        0x0173,
+	"uring":          0x016f,
+	"utilde":         0x0169,
+	"v":              0x0076,
+	"w":              0x0077,
+	"wcircumflex":    0x0175,
+	"x":              0x0078,
+	"y":              0x0079,
+	"yacute":         0x00fd,
+	"ycircumflex":    0x0177,
+	"ydieresis":      0x00ff,
+	"yen":            0x00a5,
+	"z":              0x007a,
+	"zacute":         0x017a,
+	"zcaron":         0x017e,
+	"zdotaccent":     0x017c,
+	"zero":           0x0030,
+}
@@ -0,0 +1,322 @@
+package search
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// pdfStreamObject returns a stream object holding data
+func pdfStreamObject(data string) string {
+	return fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(data), data)
+}
+
+// testPDFObjects are the objects of a PDF document of two pages
+var testPDFObjects = []string{
+	"<< /Type /Catalog /Pages 2 0 R >>",
+	"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 >>",
+	"<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 5 0 R >> >> /Contents 6 0 R >>",
+	"<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 5 0 R >> >> /Contents 7 0 R >>",
+	"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	pdfStreamObject("BT /F1 12 Tf 72 700 Td (Quarterly budget) Tj 0 -14 Td (for review) Tj ET"),
+	pdfStreamObject("BT /F1 12 Tf 72 700 Td (Approved) Tj ET"),
+}
+
+// testPDF builds a PDF file of objects numbered from 1, followed by a
+// cross-reference table giving their offsets, and returns it with the
+// offset of the table
+func testPDF(objects []string) ([]byte, int) {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return b.Bytes(), xref
+}
+
+// docLines returns the lines of the text of a document, each prefixed with
+// its location
+func docLines(doc *document) []string {
+	var lines []string
+	for i, line := range strings.Split(strings.TrimSuffix(string(doc.text), "\n"), "\n") {
+		if line != "" {
+			lines = append(lines, doc.locate(i+1)+": "+line)
+		}
+	}
+	return lines
+}
+
+func TestPDFXrefRepair(t *testing.T) {
+	intact, xref := testPDF(testPDFObjects)
+	tests := []struct {
+		name   string
+		damage func(data []byte) []byte
+		want   []string
+	}{
+		{
+			name:   "intact",
+			damage: func(data []byte) []byte { return data },
+			want:   []string{"page 1: Quarterly budget", "page 1: for review", "page 2: Approved"},
+		},
+		{
+			name: "offsets shifted",
+			damage: func(data []byte) []byte {
+				// bytes inserted after the header move every object
+				return append([]byte("%PDF-1.4\n% inserted by a mail gateway\n"), data[len("%PDF-1.4\n"):]...)
+			},
+			want: []string{"page 1: Quarterly budget", "page 1: for review", "page 2: Approved"},
+		},
+		{
+			name: "table missing",
+			damage: func(data []byte) []byte {
+				trailer := bytes.Index(data, []byte("trailer"))
+				return append(append([]byte(nil), data[:xref]...), data[trailer:]...)
+			},
+			want: []string{"page 1: Quarterly budget", "page 1: for review", "page 2: Approved"},
+		},
+		{
+			name: "trailer missing",
+			damage: func(data []byte) []byte {
+				return data[:xref]
+			},
+			want: []string{"page 1: Quarterly budget", "page 1: for review", "page 2: Approved"},
+		},
+		{
+			name: "truncated",
+			damage: func(data []byte) []byte {
+				return data[:bytes.Index(data, []byte("7 0 obj"))+20]
+			},
+			want: []string{"page 1: Quarterly budget", "page 1: for review"},
+		},
+		{
+			name: "updated without a table",
+			damage: func(data []byte) []byte {
+				update := "6 0 obj\n" + pdfStreamObject("BT /F1 12 Tf 72 700 Td (Revised budget) Tj ET") + "\nendobj\n"
+				return append(append([]byte(nil), data[:xref]...), update...)
+			},
+			want: []string{"page 1: Revised budget", "page 2: Approved"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.damage(append([]byte(nil), intact...))
+			doc := &document{limit: DefaultMaxDocumentSize}
+			if err := extract(extractPDF, bytes.NewReader(data), int64(len(data)), doc); err != nil {
+				t.Fatalf("extractPDF: %v", err)
+			}
+			if got := docLines(doc); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// testPDFXrefStream builds a PDF file of objects numbered from 1, followed
+// by a cross-reference stream with 8-byte offsets; offset replaces the
+// offset of the first object
+func testPDFXrefStream(objects []string, offset uint64) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.5\n")
+	var entries []byte
+	entry := func(kind byte, offset uint64) {
+		e := make([]byte, 10)
+		e[0] = kind
+		binary.BigEndian.PutUint64(e[1:], offset)
+		entries = append(entries, e...)
+	}
+	entry(0, 0)
+	for i, obj := range objects {
+		if i == 0 {
+			entry(1, offset)
+		} else {
+			entry(1, uint64(b.Len()))
+		}
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	entry(1, uint64(xref))
+	fmt.Fprintf(&b, "%d 0 obj\n<< /Type /XRef /Size %d /W [1 8 1] /Root 1 0 R /Length %d >>\nstream\n%s\nendstream\nendobj\n",
+		len(objects)+1, len(objects)+2, len(entries), entries)
+	fmt.Fprintf(&b, "startxref\n%d\n%%%%EOF\n", xref)
+	return b.Bytes()
+}
+
+// TestPDFOffsets checks that offsets out of the file are rejected where
+// they are read, rather than left to panic: extractPDF is called without
+// the recover of extract
+func TestPDFOffsets(t *testing.T) {
+	intact, _ := testPDF(testPDFObjects)
+	table := func(old, new string) []byte {
+		if !bytes.Contains(intact, []byte(old)) {
+			t.Fatalf("%q not in the test file", old)
+		}
+		return bytes.Replace(append([]byte(nil), intact...), []byte(old), []byte(new), 1)
+	}
+	all := []string{"page 1: Quarterly budget", "page 1: for review", "page 2: Approved"}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"negative previous section", table("/Root 1 0 R", "/Root 1 0 R /Prev -20")},
+		{"previous section after the end", table("/Root 1 0 R", "/Root 1 0 R /Prev 99999999")},
+		{"negative hybrid stream", table("/Root 1 0 R", "/Root 1 0 R /XRefStm -7")},
+		{"table offset after the end", table("0000000009 00000 n", "9999999999 00000 n")},
+		{"stream length overflowing", table("/Length 72", "/Length 9223372036854775807")},
+		{"stream offset overflowing", testPDFXrefStream(testPDFObjects, 1<<64-1)},
+		{"stream offset after the end", testPDFXrefStream(testPDFObjects, 1<<40)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := &document{limit: DefaultMaxDocumentSize}
+			if err := extractPDF(bytes.NewReader(tt.data), int64(len(tt.data)), doc); err != nil {
+				t.Fatalf("extractPDF: %v", err)
+			}
+			if got := docLines(doc); strings.Join(got, "\n") != strings.Join(all, "\n") {
+				t.Errorf("got %q, want %q", got, all)
+			}
+		})
+	}
+}
+
+func TestPDFErrors(t *testing.T) {
+	intact, _ := testPDF(testPDFObjects)
+	encrypted, _ := testPDF(testPDFObjects)
+	encrypted = bytes.Replace(encrypted, []byte("/Root 1 0 R"), []byte("/Root 1 0 R /Encrypt 8 0 R"), 1)
+	tests := []struct {
+		name  string
+		data  []byte
+		limit int64
+		want  string
+	}{
+		{"no header", []byte("1 0 obj\n<< >>\nendobj\n"), DefaultMaxDocumentSize, "PDF: missing %PDF header"},
+		{"no objects", []byte("%PDF-1.4\n%%EOF\n"), DefaultMaxDocumentSize, "PDF: no objects"},
+		{"encrypted", encrypted, DefaultMaxDocumentSize, "PDF: encrypted"},
+		{"too large", intact, 100, "PDF: larger than 0 MiB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := &document{limit: tt.limit}
+			err := extract(extractPDF, bytes.NewReader(tt.data), int64(len(tt.data)), doc)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPDFToUnicode(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmap     string
+		codeSize int
+		shown    string
+		want     string
+	}{
+		{
+			name:     "bfchar",
+			cmap:     "1 begincodespacerange <00> <FF> endcodespacerange 2 beginbfchar <41> <0042> <42> <0041> endbfchar",
+			codeSize: 1,
+			shown:    "AB",
+			want:     "BA",
+		},
+		{
+			name:     "bfrange incrementing",
+			cmap:     "1 begincodespacerange <00> <FF> endcodespacerange 1 beginbfrange <01> <03> <0061> endbfrange",
+			codeSize: 1,
+			shown:    "\x01\x02\x03",
+			want:     "abc",
+		},
+		{
+			name:     "bfrange array",
+			cmap:     "1 begincodespacerange <00> <FF> endcodespacerange 1 beginbfrange <01> <02> [<0058> /Z] endbfrange",
+			codeSize: 1,
+			shown:    "\x02\x01",
+			want:     "ZX",
+		},
+		{
+			name:     "two byte codes",
+			cmap:     "1 begincodespacerange <0000> <FFFF> endcodespacerange 2 beginbfchar <0003> <0048> <0104> <0069> endbfchar",
+			codeSize: 2,
+			shown:    "\x00\x03\x01\x04",
+			want:     "Hi",
+		},
+		{
+			name:     "mixed code lengths",
+			cmap:     "2 begincodespacerange <00> <7F> <8000> <FFFF> endcodespacerange 2 beginbfchar <41> <0041> <8141> <00E9> endbfchar",
+			codeSize: 1,
+			shown:    "A\x81\x41A",
+			want:     "AéA",
+		},
+		{
+			name:     "surrogate pair",
+			cmap:     "1 begincodespacerange <00> <FF> endcodespacerange 1 beginbfchar <05> <D83DDE00> endbfchar",
+			codeSize: 1,
+			shown:    "\x05",
+			want:     "\U0001F600",
+		},
+		{
+			name:     "ligature spelled out",
+			cmap:     "1 begincodespacerange <00> <FF> endcodespacerange 1 beginbfchar <06> <FB01> endbfchar",
+			codeSize: 1,
+			shown:    "\x06nd",
+			want:     "find",
+		},
+		{
+			name:     "unmapped code of a simple font",
+			cmap:     "1 begincodespacerange <00> <FF> endcodespacerange 1 beginbfchar <01> <0041> endbfchar",
+			codeSize: 1,
+			shown:    "\x01b",
+			want:     "Ab",
+		},
+		{
+			name:     "unmapped code of a composite font",
+			cmap:     "1 begincodespacerange <0000> <FFFF> endcodespacerange 1 beginbfchar <0001> <0041> endbfchar",
+			codeSize: 2,
+			shown:    "\x00\x01\x00\x02",
+			want:     "A",
+		},
+		{
+			name:     "bfrange ending at the last code",
+			cmap:     "1 begincodespacerange <00000000> <FFFFFFFF> endcodespacerange 1 beginbfrange <FFFFFFF0> <FFFFFFFF> <0041> endbfrange",
+			codeSize: 4,
+			shown:    "\xff\xff\xff\xf0\xff\xff\xff\xff\x00\x00\x00\x00",
+			want:     "AP",
+		},
+		{
+			name:     "bfrange array ending at the last code",
+			cmap:     "1 begincodespacerange <00000000> <FFFFFFFF> endcodespacerange 1 beginbfrange <FFFFFFFF> <FFFFFFFF> [<0058> <0059>] endbfrange",
+			codeSize: 4,
+			shown:    "\xff\xff\xff\xff\x00\x00\x00\x00",
+			want:     "X",
+		},
+		{
+			name:     "malformed entries skipped",
+			cmap:     "1 begincodespacerange <00> <FF> endcodespacerange 2 beginbfrange <05> <01> <0041> <0102> <01> <0041> endbfrange 1 beginbfchar <01> <0043> endbfchar",
+			codeSize: 1,
+			shown:    "\x01",
+			want:     "C",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &pdfFont{codeSize: tt.codeSize}
+			if tt.codeSize == 1 {
+				f = newSimpleFont(&winAnsiEncoding)
+			}
+			f.cmap = parseCMap([]byte(tt.cmap))
+			if got := f.decode([]byte(tt.shown)); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
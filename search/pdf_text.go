@@ -0,0 +1,699 @@
+package search
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// tjSpace is the smallest gap between two strings of a TJ array, in
+// thousandths of an em, read as a space between words
+const tjSpace = 180
+
+// extractPDF writes the text of each page of a PDF file, in sections
+// "page 1", "page 2" and so on
+func extractPDF(r io.ReaderAt, size int64, doc *document) error {
+	// the file is read into memory whole
+	if err := doc.fits(size, "PDF"); err != nil {
+		return err
+	}
+	p, err := newPDFReader(r, size)
+	if err != nil {
+		return err
+	}
+	n := 0
+	text := func(page, res pdfDict) {
+		n++
+		doc.begin("page " + strconv.Itoa(n))
+		t := &pdfText{p: p, doc: doc, forms: make(map[int]bool)}
+		t.run(p.contents(page), res, 0)
+	}
+	if pages := p.dict(p.dict(p.trailer["Root"])["Pages"]); pages != nil {
+		p.walkPages(pages, nil, 0, make(map[int]bool), text)
+		return nil
+	}
+
+	// without a page tree, as in a truncated file, the pages left are read
+	// in the order of their objects
+	nums := make([]int, 0, len(p.xref))
+	for num := range p.xref {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	for _, num := range nums {
+		if page, ok := p.object(num).(pdfDict); ok && page["Type"] == pdfName("Page") {
+			text(page, p.dict(p.inherited(page, "Resources")))
+		}
+	}
+	if n == 0 {
+		return docError("PDF", "no pages")
+	}
+	return nil
+}
+
+// inherited returns an attribute of a page, or of the nearest node of the
+// page tree above it that has it
+func (p *pdfReader) inherited(page pdfDict, key pdfName) pdfObject {
+	node := page
+	for i := 0; node != nil && i < 64; i++ {
+		if v, ok := node[key]; ok {
+			return v
+		}
+		node = p.dict(node["Parent"])
+	}
+	return nil
+}
+
+// walkPages calls fn for each page under a node of the page tree, in order,
+// with the resources it inherits unless it has its own
+func (p *pdfReader) walkPages(node pdfDict, res pdfObject, depth int, seen map[int]bool, fn func(page, res pdfDict)) {
+	if r, ok := node["Resources"]; ok {
+		res = r
+	}
+	kids, ok := p.resolve(node["Kids"]).(pdfArray)
+	if !ok || p.resolve(node["Type"]) == pdfName("Page") {
+		fn(node, p.dict(res))
+		return
+	}
+	if depth > 64 {
+		return
+	}
+	for _, kid := range kids {
+		if ref, ok := kid.(pdfRef); ok {
+			if seen[ref.num] {
+				continue
+			}
+			seen[ref.num] = true
+		}
+		if d := p.dict(kid); d != nil {
+			p.walkPages(d, res, depth+1, seen, fn)
+		}
+	}
+}
+
+// contents returns the decoded content streams of a page, joined
+func (p *pdfReader) contents(page pdfDict) []byte {
+	switch c := p.resolve(page["Contents"]).(type) {
+	case *pdfStream:
+		data, _ := p.decode(c)
+		return data
+	case pdfArray:
+		var data []byte
+		for _, part := range c {
+			if s, ok := p.resolve(part).(*pdfStream); ok {
+				if d, err := p.decode(s); err == nil {
+					data = append(data, d...)
+					data = append(data, '\n')
+				}
+			}
+		}
+		return data
+	}
+	return nil
+}
+
+// pdfText writes the text shown by content streams, in the order it is
+// drawn. Lines break where the baseline moves by more than half the font
+// size, and words are split where the text is moved along the line.
+type pdfText struct {
+	p     *pdfReader
+	doc   *document
+	forms map[int]bool // forms being drawn, to break loops
+
+	font     *pdfFont
+	fontSize float64
+	leading  float64
+	lineY    float64 // vertical position of the text line matrix
+	scaleY   float64 // vertical scale of the text matrix
+	ctm      pdfCTM
+	stack    []pdfCTM // graphics states saved by q
+
+	shown  bool    // text was shown on the page
+	shownY float64 // baseline of the last text shown, in user space
+	moved  bool    // the text position was set since the last text shown
+	onLine bool    // text was written on the current line
+	space  bool    // the current line ends with a space
+}
+
+// pdfCTM is the vertical part of the transformation from user space to
+// page space, enough to tell lines apart
+type pdfCTM struct {
+	scale, y float64
+}
+
+// run interprets a content stream
+func (t *pdfText) run(data []byte, res pdfDict, depth int) {
+	if depth == 0 {
+		t.ctm = pdfCTM{1, 0}
+		t.scaleY = 1
+	}
+	l := &pdfLexer{data, 0}
+	var args []pdfObject
+	for {
+		obj, err := l.object(0)
+		if err != nil {
+			return
+		}
+		op, ok := obj.(pdfOp)
+		if !ok {
+			args = append(args, obj)
+			continue
+		}
+		var last pdfObject
+		if len(args) > 0 {
+			last = args[len(args)-1]
+		}
+		num := func(i int) float64 {
+			n, _ := pdfNumber(args[len(args)-i])
+			return n
+		}
+		switch op {
+		case "q":
+			t.stack = append(t.stack, t.ctm)
+		case "Q":
+			if len(t.stack) > 0 {
+				t.ctm = t.stack[len(t.stack)-1]
+				t.stack = t.stack[:len(t.stack)-1]
+			}
+		case "cm":
+			if len(args) >= 6 {
+				t.ctm = pdfCTM{t.ctm.scale * num(3), t.ctm.scale*num(1) + t.ctm.y}
+			}
+		case "BT":
+			t.lineY, t.scaleY = 0, 1
+			t.moved = true
+		case "Tf":
+			if len(args) >= 2 {
+				if name, ok := args[len(args)-2].(pdfName); ok {
+					t.font = t.p.font(res, name)
+				}
+				t.fontSize = num(1)
+			}
+		case "TL":
+			if len(args) >= 1 {
+				t.leading = num(1)
+			}
+		case "Td", "TD":
+			if len(args) >= 2 {
+				t.lineY += num(1) * t.scaleY
+				if op == "TD" {
+					t.leading = -num(1)
+				}
+				t.moved = true
+			}
+		case "Tm":
+			if len(args) >= 6 {
+				t.lineY, t.scaleY = num(1), num(3)
+				t.moved = true
+			}
+		case "T*":
+			t.nextLine()
+		case "Tj":
+			t.show(last)
+		case "'", "\"":
+			t.nextLine()
+			t.show(last)
+		case "TJ":
+			items, _ := last.(pdfArray)
+			for _, item := range items {
+				if n, ok := pdfNumber(item); ok {
+					if n < -tjSpace {
+						t.gap()
+					}
+					continue
+				}
+				t.show(item)
+			}
+		case "Do":
+			if name, ok := last.(pdfName); ok {
+				t.form(res, name, depth)
+			}
+		case "BI":
+			l.skipInlineImage()
+		}
+		args = args[:0]
+	}
+}
+
+// nextLine moves to the next line, by the leading if it is set
+func (t *pdfText) nextLine() {
+	if t.leading == 0 {
+		t.newline()
+	}
+	t.lineY -= t.leading * t.scaleY
+	t.moved = true
+}
+
+// form draws the text of a form XObject
+func (t *pdfText) form(res pdfDict, name pdfName, depth int) {
+	ref := t.p.dict(res["XObject"])[name]
+	s, ok := t.p.resolve(ref).(*pdfStream)
+	if !ok || t.p.resolve(s.dict["Subtype"]) != pdfName("Form") || depth >= 8 {
+		return
+	}
+	if r, ok := ref.(pdfRef); ok {
+		if t.forms[r.num] {
+			return
+		}
+		t.forms[r.num] = true
+		defer delete(t.forms, r.num)
+	}
+	data, err := t.p.decode(s)
+	if err != nil {
+		return
+	}
+	formRes := t.p.dict(s.dict["Resources"])
+	if formRes == nil {
+		formRes = res
+	}
+	font := t.font
+	t.run(data, formRes, depth+1)
+	t.font = font
+}
+
+// show writes a shown string in the current font, on a new line if the
+// baseline moved
+func (t *pdfText) show(obj pdfObject) {
+	s, ok := obj.(pdfString)
+	if !ok {
+		return
+	}
+	font := t.font
+	if font == nil {
+		font = defaultFont
+	}
+	text := font.decode(s)
+	if text == "" {
+		return
+	}
+	y := t.ctm.scale*t.lineY + t.ctm.y
+	if t.shown {
+		height := t.fontSize * t.scaleY * t.ctm.scale
+		if dy := y - t.shownY; dy*dy > height*height/4 {
+			t.newline()
+		} else if t.moved {
+			t.gap()
+		}
+	}
+	t.shown, t.shownY, t.moved = true, y, false
+	t.doc.WriteString(text)
+	t.onLine = true
+	t.space = strings.HasSuffix(text, " ")
+}
+
+// gap separates words on a line
+func (t *pdfText) gap() {
+	if t.onLine && !t.space {
+		t.doc.WriteString(" ")
+		t.space = true
+	}
+}
+
+// newline ends the current line
+func (t *pdfText) newline() {
+	if t.onLine {
+		t.doc.WriteString("\n")
+		t.onLine, t.space = false, false
+	}
+}
+
+// pdfNumber returns the value of an integer or real object
+func pdfNumber(obj pdfObject) (float64, bool) {
+	switch n := obj.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// skipInlineImage skips the parameters and data of an inline image, after
+// the BI operator
+func (l *pdfLexer) skipInlineImage() {
+	for {
+		obj, err := l.object(0)
+		if err != nil {
+			return
+		}
+		if obj == pdfOp("ID") {
+			break
+		}
+	}
+	l.pos++
+	for l.pos < len(l.data) {
+		i := bytes.Index(l.data[l.pos:], []byte("EI"))
+		if i < 0 {
+			l.pos = len(l.data)
+			return
+		}
+		at := l.pos + i
+		l.pos = at + 2
+		if isPDFSpace(l.data[at-1]) && (l.pos == len(l.data) || isPDFSpace(l.data[l.pos])) {
+			return
+		}
+	}
+}
+
+// pdfFont turns the character codes of shown strings into text
+type pdfFont struct {
+	cmap     *pdfCMap    // ToUnicode mapping, if the font has one
+	codeSize int         // bytes per code outside the code space of cmap
+	enc      [256]string // text of each code of a simple font
+}
+
+// defaultFont decodes strings shown without a font
+var defaultFont = newSimpleFont(&winAnsiEncoding)
+
+func newSimpleFont(base *[256]rune) *pdfFont {
+	f := &pdfFont{codeSize: 1}
+	for i, r := range base {
+		if r != 0 {
+			f.enc[i] = ligatures.Replace(string(r))
+		}
+	}
+	return f
+}
+
+// decode returns the text of a shown string
+func (f *pdfFont) decode(s []byte) string {
+	var b bytes.Buffer
+	for len(s) > 0 {
+		n := f.codeSize
+		if f.cmap != nil {
+			n = f.cmap.codeLen(s, n)
+		}
+		if n > len(s) {
+			n = len(s)
+		}
+		code := s[:n]
+		s = s[n:]
+		if f.cmap != nil {
+			if text, ok := f.cmap.chars[string(code)]; ok {
+				b.WriteString(text)
+				continue
+			}
+		}
+		if n == 1 {
+			b.WriteString(f.enc[code[0]])
+		}
+	}
+	return b.String()
+}
+
+// font returns a font of a resource dictionary by name
+func (p *pdfReader) font(res pdfDict, name pdfName) *pdfFont {
+	ref := p.dict(res["Font"])[name]
+	r, ok := ref.(pdfRef)
+	if !ok {
+		return p.loadFont(p.dict(ref))
+	}
+	if f, ok := p.fonts[r.num]; ok {
+		return f
+	}
+	f := p.loadFont(p.dict(ref))
+	p.fonts[r.num] = f
+	return f
+}
+
+// loadFont reads how a font maps codes to text: its ToUnicode CMap, and
+// for simple fonts their encoding. Composite fonts without a ToUnicode
+// CMap use glyph ids whose text is unknown.
+func (p *pdfReader) loadFont(d pdfDict) *pdfFont {
+	subtype := p.resolve(d["Subtype"])
+	var f *pdfFont
+	if subtype == pdfName("Type0") {
+		f = &pdfFont{codeSize: 2}
+	} else {
+		base := &standardEncoding
+		if subtype == pdfName("TrueType") {
+			base = &winAnsiEncoding
+		}
+		var differences pdfArray
+		named := false
+		switch enc := p.resolve(d["Encoding"]).(type) {
+		case pdfName:
+			named = encodingNamed(enc, &base)
+		case pdfDict:
+			if name, ok := p.resolve(enc["BaseEncoding"]).(pdfName); ok {
+				named = encodingNamed(name, &base)
+			}
+			differences, _ = p.resolve(enc["Differences"]).(pdfArray)
+		}
+		f = newSimpleFont(base)
+
+		// an embedded Type 1 font may have an encoding of its own
+		if !named && subtype == pdfName("Type1") {
+			if builtin := p.builtinEncoding(d); builtin != nil {
+				f.enc = [256]string{}
+				for code, glyph := range builtin {
+					f.enc[code] = glyphText(glyph)
+				}
+			}
+		}
+		code := 0
+		for _, item := range differences {
+			switch v := p.resolve(item).(type) {
+			case int64:
+				code = int(v)
+			case pdfName:
+				if code >= 0 && code < 256 {
+					f.enc[code] = glyphText(string(v))
+				}
+				code++
+			}
+		}
+	}
+
+	if s, ok := p.resolve(d["ToUnicode"]).(*pdfStream); ok {
+		if data, err := p.decode(s); err == nil {
+			f.cmap = parseCMap(data)
+		}
+	}
+	return f
+}
+
+// encodingNamed sets base to a predefined encoding, and tells whether the
+// name is one
+func encodingNamed(name pdfName, base **[256]rune) bool {
+	switch name {
+	case "StandardEncoding":
+		*base = &standardEncoding
+	case "WinAnsiEncoding":
+		*base = &winAnsiEncoding
+	case "MacRomanEncoding":
+		*base = &macRomanEncoding
+	default:
+		return false
+	}
+	return true
+}
+
+var type1Encoding = regexp.MustCompile(`dup\s+(\d+)\s*/([^\s/\[\]{}()<>]+)\s+put`)
+
+// builtinEncoding reads the glyph names by code that the clear text part
+// of an embedded Type 1 font program defines, if it defines its own
+// encoding
+func (p *pdfReader) builtinEncoding(font pdfDict) map[int]string {
+	s, ok := p.resolve(p.dict(font["FontDescriptor"])["FontFile"]).(*pdfStream)
+	if !ok {
+		return nil
+	}
+	data, err := p.decode(s)
+	if err != nil {
+		return nil
+	}
+	if n, ok := p.resolve(s.dict["Length1"]).(int64); ok && n > 0 && int(n) < len(data) {
+		data = data[:n]
+	}
+	if !bytes.Contains(data, []byte("/Encoding")) || bytes.Contains(data, []byte("StandardEncoding def")) {
+		return nil
+	}
+	enc := make(map[int]string)
+	for _, m := range type1Encoding.FindAllSubmatch(data, -1) {
+		if code, err := strconv.Atoi(string(m[1])); err == nil && code < 256 {
+			enc[code] = string(m[2])
+		}
+	}
+	if len(enc) == 0 {
+		return nil
+	}
+	return enc
+}
+
+// ligatures spells out ligature characters, so that "ﬁnd" matches "find"
+var ligatures = strings.NewReplacer(
+	"ﬀ", "ff", "ﬁ", "fi", "ﬂ", "fl", "ﬃ", "ffi", "ﬄ", "ffl", "ﬅ", "st", "ﬆ", "st",
+)
+
+// glyphText returns the text of a glyph name, as listed in the Adobe Glyph
+// List or spelled uniXXXX, uXXXX[XX] or with components joined by "_"
+func glyphText(name string) string {
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		name = name[:i]
+	}
+	if r, ok := glyphNames[name]; ok {
+		return ligatures.Replace(string(r))
+	}
+	if strings.Contains(name, "_") {
+		var text string
+		for _, part := range strings.Split(name, "_") {
+			text += glyphText(part)
+		}
+		return text
+	}
+	if strings.HasPrefix(name, "uni") && len(name) > 3 && (len(name)-3)%4 == 0 {
+		var units []uint16
+		for i := 3; i < len(name); i += 4 {
+			u, err := strconv.ParseUint(name[i:i+4], 16, 16)
+			if err != nil {
+				return ""
+			}
+			units = append(units, uint16(u))
+		}
+		return ligatures.Replace(string(utf16.Decode(units)))
+	}
+	if strings.HasPrefix(name, "u") && len(name) >= 5 && len(name) <= 7 {
+		if r, err := strconv.ParseUint(name[1:], 16, 32); err == nil {
+			return ligatures.Replace(string(rune(r)))
+		}
+	}
+	return ""
+}
+
+// pdfCMap maps character codes to text, as a ToUnicode CMap does
+type pdfCMap struct {
+	space []codeRange       // code space, telling the length of each code
+	chars map[string]string // text by code
+}
+
+// codeRange is a range of codes of the same length
+type codeRange struct {
+	lo, hi []byte
+}
+
+// codeLen returns the length of the code at the start of s, or size if it
+// is outside the code space
+func (c *pdfCMap) codeLen(s []byte, size int) int {
+	for _, r := range c.space {
+		n := len(r.lo)
+		if n > len(s) {
+			continue
+		}
+		in := true
+		for i := 0; i < n && in; i++ {
+			in = s[i] >= r.lo[i] && s[i] <= r.hi[i]
+		}
+		if in {
+			return n
+		}
+	}
+	return size
+}
+
+// parseCMap reads the code space and the bfchar and bfrange mappings of a
+// CMap
+func parseCMap(data []byte) *pdfCMap {
+	c := &pdfCMap{chars: make(map[string]string)}
+	l := &pdfLexer{data, 0}
+	var args []pdfObject
+	for {
+		obj, err := l.object(0)
+		if err != nil {
+			return c
+		}
+		op, ok := obj.(pdfOp)
+		if !ok {
+			args = append(args, obj)
+			continue
+		}
+		switch op {
+		case "endcodespacerange":
+			for i := 0; i+1 < len(args); i += 2 {
+				lo, ok1 := args[i].(pdfString)
+				hi, ok2 := args[i+1].(pdfString)
+				if ok1 && ok2 && len(lo) == len(hi) && len(lo) > 0 {
+					c.space = append(c.space, codeRange{lo, hi})
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(args); i += 2 {
+				if src, ok := args[i].(pdfString); ok {
+					c.chars[string(src)] = cmapText(args[i+1])
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(args); i += 3 {
+				c.bfrange(args[i], args[i+1], args[i+2])
+			}
+		}
+		args = args[:0]
+	}
+}
+
+// bfrange maps a range of codes: to consecutive text, incrementing the last
+// byte of dst, or to the items of an array
+func (c *pdfCMap) bfrange(loObj, hiObj, dst pdfObject) {
+	lo, ok1 := loObj.(pdfString)
+	hi, ok2 := hiObj.(pdfString)
+	if !ok1 || !ok2 || len(lo) != len(hi) || len(lo) == 0 || len(lo) > 4 {
+		return
+	}
+	from, to := codeValue(lo), codeValue(hi)
+	if to < from || to-from > 0xffff {
+		return
+	}
+	code := func(v uint32) string {
+		b := make([]byte, len(lo))
+		for i := len(b) - 1; i >= 0; i-- {
+			b[i] = byte(v)
+			v >>= 8
+		}
+		return string(b)
+	}
+	switch dst := dst.(type) {
+	case pdfString:
+		next := append([]byte(nil), dst...)
+		// counted in 64 bits, as a range may end at 0xFFFFFFFF
+		for v := uint64(from); v <= uint64(to); v++ {
+			c.chars[code(uint32(v))] = cmapText(pdfString(next))
+			if len(next) > 0 {
+				next[len(next)-1]++
+			}
+		}
+	case pdfArray:
+		for i, d := range dst {
+			if v := uint64(from) + uint64(i); v <= uint64(to) {
+				c.chars[code(uint32(v))] = cmapText(d)
+			}
+		}
+	}
+}
+
+func codeValue(b []byte) uint32 {
+	var v uint32
+	for _, c := range b {
+		v = v<<8 | uint32(c)
+	}
+	return v
+}
+
+// cmapText returns the text a CMap maps a code to: UTF-16BE, or a glyph
+// name
+func cmapText(obj pdfObject) string {
+	switch s := obj.(type) {
+	case pdfString:
+		units := make([]uint16, len(s)/2)
+		for i := range units {
+			units[i] = uint16(s[2*i])<<8 | uint16(s[2*i+1])
+		}
+		return ligatures.Replace(string(utf16.Decode(units)))
+	case pdfName:
+		return glyphText(string(s))
+	}
+	return ""
+}
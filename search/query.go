@@ -0,0 +1,338 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Scopes of a boolean query
+const (
+	ScopeFile      = "file"      // terms may occur anywhere in the file
+	ScopeParagraph = "paragraph" // terms must occur between the same blank lines
+	ScopeLine      = "line"      // terms must occur on the same line
+)
+
+// matchMode selects how query terms are compiled
+type matchMode struct {
+	matcher    MatcherFunc
+	ignoreCase bool
+	distance   int
+}
+
+// newTerm compiles a search term for the selected match mode
+func (m matchMode) newTerm(text string) (Matcher, error) {
+	return m.matcher(MatcherConfig{Term: text, IgnoreCase: m.ignoreCase, Distance: m.distance})
+}
+
+// queryNode is a node of a parsed boolean query; seen holds whether each
+// term of the query occurs in the text being evaluated
+type queryNode interface {
+	eval(seen []bool) bool
+}
+
+type termNode struct {
+	index int // index into query terms
+}
+
+type notNode struct {
+	operand queryNode
+}
+
+type andNode struct {
+	left, right queryNode
+}
+
+type orNode struct {
+	left, right queryNode
+}
+
+func (n termNode) eval(seen []bool) bool { return seen[n.index] }
+func (n notNode) eval(seen []bool) bool  { return !n.operand.eval(seen) }
+func (n andNode) eval(seen []bool) bool  { return n.left.eval(seen) && n.right.eval(seen) }
+func (n orNode) eval(seen []bool) bool   { return n.left.eval(seen) || n.right.eval(seen) }
+
+// query is a parsed boolean query and its compiled terms
+type query struct {
+	root     queryNode
+	terms    []Matcher
+	positive []bool // terms not under a NOT, whose occurrences are reported
+	scope    string
+}
+
+// QueryError is a query parse error at a 1-based column of the query text
+type QueryError struct {
+	Column int
+	Msg    string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Column, e.Msg)
+}
+
+// token kinds produced by lexQuery
+const (
+	tokTerm = iota
+	tokAnd
+	tokOr
+	tokNot
+	tokOpen
+	tokClose
+	tokEnd
+)
+
+type queryToken struct {
+	kind   int
+	text   string
+	column int
+}
+
+// lexQuery splits query text into tokens. AND, OR and NOT are operators only
+// in upper case; double quotes group a phrase, in which \" and \\ are escapes.
+func lexQuery(text string) ([]queryToken, error) {
+	var tokens []queryToken
+	column := 0
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		column++
+		switch {
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			i += size
+		case r == '(':
+			tokens = append(tokens, queryToken{tokOpen, "(", column})
+			i += size
+		case r == ')':
+			tokens = append(tokens, queryToken{tokClose, ")", column})
+			i += size
+		case r == '"':
+			start := column
+			var phrase []rune
+			i += size
+			closed := false
+			for i < len(text) {
+				r, size = utf8.DecodeRuneInString(text[i:])
+				i += size
+				column++
+				if r == '"' {
+					closed = true
+					break
+				}
+				if r == '\\' && i < len(text) && (text[i] == '"' || text[i] == '\\') {
+					r = rune(text[i])
+					i++
+					column++
+				}
+				phrase = append(phrase, r)
+			}
+			if !closed {
+				return nil, &QueryError{start, "unterminated quoted phrase"}
+			}
+			if len(phrase) == 0 {
+				return nil, &QueryError{start, "empty quoted phrase"}
+			}
+			tokens = append(tokens, queryToken{tokTerm, string(phrase), start})
+		default:
+			start := column
+			j := i
+			for j < len(text) && !strings.ContainsRune(" \t\r\n()\"", rune(text[j])) {
+				j++
+			}
+			word := text[i:j]
+			column += utf8.RuneCountInString(word) - 1
+			kind := tokTerm
+			switch word {
+			case "AND":
+				kind = tokAnd
+			case "OR":
+				kind = tokOr
+			case "NOT":
+				kind = tokNot
+			}
+			tokens = append(tokens, queryToken{kind, word, start})
+			i = j
+		}
+	}
+	tokens = append(tokens, queryToken{tokEnd, "", column + 1})
+	return tokens, nil
+}
+
+// queryParser is a recursive descent parser over the grammar
+//
+//	or      = and { "OR" and }
+//	and     = unary { [ "AND" ] unary }
+//	unary   = "NOT" unary | primary
+//	primary = "(" or ")" | term
+type queryParser struct {
+	tokens  []queryToken
+	pos     int
+	negated bool // parsing the operand of an odd number of NOTs
+	mode    matchMode
+	q       *query
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() queryToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokEnd {
+		p.pos++
+	}
+	return t
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek().kind {
+		case tokAnd:
+			p.next()
+		case tokTerm, tokNot, tokOpen:
+			// adjacent terms are joined with an implicit AND
+		default:
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	if p.peek().kind == tokNot {
+		p.next()
+		p.negated = !p.negated
+		operand, err := p.parseUnary()
+		p.negated = !p.negated
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokOpen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokClose {
+			return nil, &QueryError{closing.column, "expected ')'"}
+		}
+		return node, nil
+	case tokTerm:
+		k, err := p.mode.newTerm(t.text)
+		if err != nil {
+			return nil, &QueryError{t.column, err.Error()}
+		}
+		p.q.terms = append(p.q.terms, k)
+		p.q.positive = append(p.q.positive, !p.negated)
+		return termNode{len(p.q.terms) - 1}, nil
+	case tokEnd:
+		return nil, &QueryError{t.column, "unexpected end of query, expected a term"}
+	default:
+		return nil, &QueryError{t.column, fmt.Sprintf("unexpected %q, expected a term", t.text)}
+	}
+}
+
+// parseQuery parses a boolean query such as (timeout OR deadline) AND NOT test
+func parseQuery(text string, scope string, mode matchMode) (*query, error) {
+	tokens, err := lexQuery(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens, mode: mode, q: &query{scope: scope}}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEnd {
+		return nil, &QueryError{t.column, fmt.Sprintf("unexpected %q", t.text)}
+	}
+	p.q.root = root
+	return p.q, nil
+}
+
+// singleQuery wraps one keyword in a query, for plain (non-boolean) searches
+func singleQuery(text string, scope string, mode matchMode) (*query, error) {
+	k, err := mode.newTerm(text)
+	if err != nil {
+		return nil, err
+	}
+	return &query{root: termNode{0}, terms: []Matcher{k}, positive: []bool{true}, scope: scope}, nil
+}
+
+// matchName tells whether a file or folder name matches the query: it
+// satisfies the query, ignoring scope, and holds an occurrence of one of its
+// positive terms, as a line of a file must to be reported. A name that only
+// lacks the terms of NOT test, say, does not match.
+func (q *query) matchName(name []byte) bool {
+	seen := make([]bool, len(q.terms))
+	occurs := false
+	for i, k := range q.terms {
+		seen[i] = k.Match(name)
+		occurs = occurs || seen[i] && q.positive[i]
+	}
+	return occurs && q.root.eval(seen)
+}
+
+// overlap returns how many bytes split pieces of a long line must share so
+// that no occurrence of a term is cut in two
+func (q *query) overlap() int {
+	overlap := 0
+	for _, k := range q.terms {
+		if n := k.MaxLen() - 1; n > overlap {
+			overlap = n
+		}
+	}
+	return overlap
+}
+
+// twoPass reports whether content must be read twice to search it: once to
+// find the units satisfying the query, then to report its matches there
+func (q *query) twoPass() bool {
+	return q.scope != ScopeLine && !q.disjunctive()
+}
+
+// disjunctive reports whether the query only ORs positive terms, so that a
+// unit satisfies it exactly when one of its terms occurs there
+func (q *query) disjunctive() bool {
+	var walk func(n queryNode) bool
+	walk = func(n queryNode) bool {
+		switch n := n.(type) {
+		case termNode:
+			return true
+		case orNode:
+			return walk(n.left) && walk(n.right)
+		default:
+			return false
+		}
+	}
+	return walk(q.root)
+}
@@ -0,0 +1,165 @@
+package search
+
+import (
+	"fmt"
+	"testing"
+)
+
+// recordingMode returns a literal match mode that records the text of the
+// terms it compiles
+func recordingMode(texts *[]string) matchMode {
+	return matchMode{matcher: func(cfg MatcherConfig) (Matcher, error) {
+		*texts = append(*texts, cfg.Term)
+		return newLiteral(cfg)
+	}}
+}
+
+// queryString renders a parsed query with parentheses around every binary
+// node, and a "-" before terms that are not positive
+func queryString(q *query, texts []string) string {
+	var render func(n queryNode) string
+	render = func(n queryNode) string {
+		switch n := n.(type) {
+		case termNode:
+			if !q.positive[n.index] {
+				return "-" + texts[n.index]
+			}
+			return texts[n.index]
+		case notNode:
+			return "NOT " + render(n.operand)
+		case andNode:
+			return "(" + render(n.left) + " AND " + render(n.right) + ")"
+		case orNode:
+			return "(" + render(n.left) + " OR " + render(n.right) + ")"
+		}
+		return fmt.Sprintf("%T", n)
+	}
+	return render(q.root)
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"timeout", "timeout"},
+		{"a b", "(a AND b)"},
+		{"a AND b OR c", "((a AND b) OR c)"},
+		{"a OR b c", "(a OR (b AND c))"},
+		{"(a OR b) c", "((a OR b) AND c)"},
+		{"NOT a OR b", "(NOT -a OR b)"},
+		{"NOT NOT a", "NOT NOT a"},
+		{"a AND NOT (b OR NOT c)", "(a AND NOT (-b OR NOT c))"},
+		{"and or not", "((and AND or) AND not)"},
+		{`"x AND y" z`, "(x AND y AND z)"},
+		{`"a\\b\"c"`, `a\b"c`},
+		{"\t(\ta\n)\r", "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			var texts []string
+			q, err := parseQuery(tt.text, ScopeFile, recordingMode(&texts))
+			if err != nil {
+				t.Fatalf("parseQuery: %v", err)
+			}
+			if got := queryString(q, texts); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		text   string
+		regex  bool
+		column int
+		msg    string
+	}{
+		{"", false, 1, "unexpected end of query, expected a term"},
+		{"a AND", false, 6, "unexpected end of query, expected a term"},
+		{"NOT", false, 4, "unexpected end of query, expected a term"},
+		{"a OR OR b", false, 6, `unexpected "OR", expected a term`},
+		{"()", false, 2, `unexpected ")", expected a term`},
+		{"(a OR b", false, 8, "expected ')'"},
+		{"(a b c", false, 7, "expected ')'"},
+		{"a)", false, 2, `unexpected ")"`},
+		{`"abc`, false, 1, "unterminated quoted phrase"},
+		{`x ""`, false, 3, "empty quoted phrase"},
+		{`é AND "ü`, false, 7, "unterminated quoted phrase"},
+		{`"a\"b" )`, false, 8, `unexpected ")"`},
+		{"über ) x", false, 6, `unexpected ")"`},
+		{"a AND [b", true, 7, "error parsing regexp: missing closing ]: `[b`"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			mode := matchMode{matcher: newLiteral}
+			if tt.regex {
+				mode.matcher = newRegex
+			}
+			_, err := parseQuery(tt.text, ScopeFile, mode)
+			qe, ok := err.(*QueryError)
+			if !ok {
+				t.Fatalf("got error %v, want a *QueryError", err)
+			}
+			if qe.Column != tt.column || qe.Msg != tt.msg {
+				t.Errorf("got column %d: %s, want column %d: %s", qe.Column, qe.Msg, tt.column, tt.msg)
+			}
+		})
+	}
+}
+
+func TestQueryMatchName(t *testing.T) {
+	tests := []struct {
+		query string
+		name  string
+		want  bool
+	}{
+		{"report", "report.txt", true},
+		{"report", "notes.txt", false},
+		{"NOT test", "src", false},
+		{"NOT test", "test", false},
+		{"a AND NOT b", "a.txt", true},
+		{"a AND NOT b", "ab.txt", false},
+		{"foo OR NOT test", "src", false},
+		{"foo OR NOT test", "foo", true},
+		{"NOT NOT foo", "foo", true},
+		{"x y", "x", false},
+		{"x y", "xy", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query+"/"+tt.name, func(t *testing.T) {
+			q, err := parseQuery(tt.query, ScopeLine, matchMode{matcher: newLiteral})
+			if err != nil {
+				t.Fatalf("parseQuery: %v", err)
+			}
+			if got := q.matchName([]byte(tt.name)); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryDisjunctive(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"a", true},
+		{"a OR b OR (c OR d)", true},
+		{"a b", false},
+		{"a OR NOT b", false},
+		{"NOT a", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := parseQuery(tt.query, ScopeFile, matchMode{matcher: newLiteral})
+			if err != nil {
+				t.Fatalf("parseQuery: %v", err)
+			}
+			if got := q.disjunctive(); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
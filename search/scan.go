@@ -0,0 +1,314 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"unicode/utf8"
+)
+
+const (
+	chunkSize    = 64 * 1024 // size of the reusable read buffers
+	regexOverlap = 4 * 1024  // overlap kept for regex terms when a line is split
+	maxLineText  = chunkSize // longest line text kept for a match or context
+)
+
+// Match is a single keyword occurrence within a file
+type Match struct {
+	Line     int           // 1-based line number
+	Column   int           // 1-based column, in characters
+	Offset   int64         // byte offset from the start of the file, or of the text extracted from a document
+	Location string        // section of a document holding the match, such as "page 3"; empty for plain files
+	Text     string        // text of the matching line
+	Before   []ContextLine // context lines preceding the match
+	After    []ContextLine // context lines following the match
+}
+
+// ContextLine is a line of text surrounding a match
+type ContextLine struct {
+	Line int
+	Text string
+}
+
+// piece is a line of content, or part of a line too long for the buffer
+type piece struct {
+	text   []byte // valid only until the callback returns
+	offset int64  // byte offset of text from the start of the file
+	line   int    // 1-based line number
+	column int    // 1-based column of text[0] within the line, in characters
+	final  bool   // piece ends its line
+	keep   int    // matches starting at or after keep are repeated in the next piece
+}
+
+// scanLines reads r through buf and calls fn for each line. A line that does
+// not fit in buf is passed as several pieces, each overlapping the next by
+// overlap bytes so that a match across the cut is found in one of them.
+// Reading stops with ctx.Err() once ctx is done.
+func scanLines(ctx context.Context, r io.Reader, buf []byte, overlap int, fn func(p piece)) error {
+	if overlap > len(buf)/2 {
+		overlap = len(buf) / 2
+	}
+	n := 0         // bytes held in buf
+	var base int64 // file offset of buf[0]
+	line, column := 1, 1
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		m, err := io.ReadFull(r, buf[n:])
+		n += m
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return err
+		}
+		data := buf[:n]
+		pos := 0
+		for {
+			i := bytes.IndexByte(data[pos:], '\n')
+			if i < 0 {
+				break
+			}
+			text := bytes.TrimSuffix(data[pos:pos+i], []byte("\r"))
+			fn(piece{text, base + int64(pos), line, column, true, len(text)})
+			line++
+			column = 1
+			pos += i + 1
+		}
+		rest := data[pos:]
+		switch {
+		case eof:
+			if len(rest) > 0 {
+				text := bytes.TrimSuffix(rest, []byte("\r"))
+				fn(piece{text, base + int64(pos), line, column, true, len(text)})
+			}
+			return nil
+		case pos == 0:
+			// buffer full without a newline: pass on a piece and keep the
+			// overlap, cut on a character boundary
+			cut := len(data) - overlap
+			for cut > 0 && !utf8.RuneStart(data[cut]) {
+				cut--
+			}
+			if cut == 0 {
+				cut = len(data) - overlap
+			}
+			fn(piece{data, base, line, column, false, cut})
+			column += utf8.RuneCount(data[:cut])
+			n = copy(buf, data[cut:])
+			base += int64(cut)
+		default:
+			n = copy(buf, rest)
+			base += int64(pos)
+		}
+	}
+}
+
+// fileScan evaluates a query over the lines of one file and collects the
+// matches of its positive terms, with context, in a single streaming pass.
+// Scope units are lines, paragraphs separated by blank lines, or the file.
+type fileScan struct {
+	q             *query
+	before, after int
+	evalOnly      bool           // only record which units satisfy the query
+	allowed       func(int) bool // units whose matches are reported; nil means all
+	satisfied     map[int]bool   // units found to satisfy the query, in evalOnly mode
+
+	found     bool          // some unit satisfies the query
+	matches   []Match       // reported matches
+	unit      int           // index of the current scope unit
+	inUnit    bool          // current paragraph has a non-blank line
+	unitSeen  []bool        // terms seen in the current unit
+	lineSeen  []bool        // terms seen on the current line
+	pending   []Match       // matches on the current line
+	lineText  []byte        // text of the current line, up to maxLineText
+	blank     bool          // current line is blank so far
+	ring      []ContextLine // last lines, for before context
+	shown     int           // last line attached to a match
+	afterLeft int           // after context lines still owed to the last match
+}
+
+func newFileScan(q *query, before, after int) *fileScan {
+	return &fileScan{
+		q:        q,
+		before:   before,
+		after:    after,
+		unitSeen: make([]bool, len(q.terms)),
+		lineSeen: make([]bool, len(q.terms)),
+		blank:    true,
+	}
+}
+
+// piece searches a line or part of a line
+func (s *fileScan) piece(p piece) {
+	for i, k := range s.q.terms {
+		if !s.q.positive[i] || s.evalOnly {
+			if !s.lineSeen[i] && k.Match(p.text) {
+				s.lineSeen[i] = true
+			}
+			continue
+		}
+		for _, sp := range k.FindAll(p.text) {
+			if !p.final && sp.Start >= p.keep {
+				break
+			}
+			s.lineSeen[i] = true
+			if sp.End == sp.Start {
+				continue
+			}
+			s.pending = append(s.pending, Match{
+				Line:   p.line,
+				Column: p.column + utf8.RuneCount(p.text[:sp.Start]),
+				Offset: p.offset + int64(sp.Start),
+			})
+		}
+	}
+	if s.blank && len(bytes.TrimSpace(p.text)) > 0 {
+		s.blank = false
+	}
+	if !s.evalOnly {
+		text := p.text[:p.keep]
+		if room := maxLineText - len(s.lineText); len(text) > room {
+			text = text[:room]
+		}
+		s.lineText = append(s.lineText, text...)
+	}
+	if p.final {
+		s.endLine(p.line)
+	}
+}
+
+// endLine closes the current line, and the current unit when it ends there
+func (s *fileScan) endLine(line int) {
+	if s.q.scope == ScopeParagraph && s.blank && s.inUnit {
+		s.endUnit()
+	}
+	if !s.blank {
+		s.inUnit = true
+	}
+	for i, seen := range s.lineSeen {
+		if seen {
+			s.unitSeen[i] = true
+		}
+	}
+
+	report := true
+	switch {
+	case s.q.scope == ScopeLine:
+		report = s.q.root.eval(s.lineSeen)
+		s.endUnit()
+	case s.allowed != nil:
+		report = s.allowed(s.unit)
+	}
+	if report && len(s.pending) > 0 {
+		s.commit(line)
+	} else if !s.evalOnly {
+		s.context(line)
+	}
+
+	for i := range s.lineSeen {
+		s.lineSeen[i] = false
+	}
+	s.pending = s.pending[:0]
+	s.lineText = s.lineText[:0]
+	s.blank = true
+}
+
+// endUnit evaluates the query over the unit that just ended
+func (s *fileScan) endUnit() {
+	if s.q.root.eval(s.unitSeen) {
+		s.found = true
+		if s.evalOnly {
+			s.satisfied[s.unit] = true
+		}
+	}
+	for i := range s.unitSeen {
+		s.unitSeen[i] = false
+	}
+	s.unit++
+	s.inUnit = false
+}
+
+// commit reports the matches on a line, with context
+func (s *fileScan) commit(line int) {
+	sort.Slice(s.pending, func(i, j int) bool { return s.pending[i].Offset < s.pending[j].Offset })
+	text := string(s.lineText)
+	first := len(s.matches)
+	for i, m := range s.pending {
+		if i > 0 && m.Offset == s.pending[i-1].Offset {
+			continue
+		}
+		m.Text = text
+		s.matches = append(s.matches, m)
+	}
+	for _, c := range s.ring {
+		if c.Line > s.shown {
+			s.matches[first].Before = append(s.matches[first].Before, c)
+		}
+	}
+	s.ring = s.ring[:0]
+	s.shown = line
+	s.afterLeft = s.after
+}
+
+// context attaches a line without reported matches as after context of the
+// last match, or keeps it as before context for the next one
+func (s *fileScan) context(line int) {
+	if s.afterLeft > 0 && len(s.matches) > 0 {
+		last := &s.matches[len(s.matches)-1]
+		last.After = append(last.After, ContextLine{line, string(s.lineText)})
+		s.afterLeft--
+		s.shown = line
+		return
+	}
+	if s.before == 0 {
+		return
+	}
+	if len(s.ring) == s.before {
+		copy(s.ring, s.ring[1:])
+		s.ring = s.ring[:len(s.ring)-1]
+	}
+	s.ring = append(s.ring, ContextLine{line, string(s.lineText)})
+}
+
+// finish closes the last unit once the whole file has been scanned
+func (s *fileScan) finish() {
+	if s.q.scope != ScopeLine && (s.inUnit || s.q.scope == ScopeFile) {
+		s.endUnit()
+	}
+}
+
+// scanContent searches content read from r. Queries whose units can hold
+// without a reported match, such as a AND b, first need a pass to find the
+// units that satisfy them; rewind is then called to read the content again.
+func scanContent(ctx context.Context, r io.Reader, rewind func() (io.Reader, error), buf []byte, q *query, before, after int) (bool, []Match, error) {
+	overlap := q.overlap()
+	if q.twoPass() {
+		eval := newFileScan(q, 0, 0)
+		eval.evalOnly = true
+		eval.satisfied = make(map[int]bool)
+		if err := scanLines(ctx, r, buf, overlap, eval.piece); err != nil {
+			return false, nil, err
+		}
+		eval.finish()
+		if !eval.found {
+			return false, nil, nil
+		}
+		var err error
+		if r, err = rewind(); err != nil {
+			return false, nil, err
+		}
+		s := newFileScan(q, before, after)
+		s.allowed = func(unit int) bool { return eval.satisfied[unit] }
+		if err := scanLines(ctx, r, buf, overlap, s.piece); err != nil {
+			return false, nil, err
+		}
+		return true, s.matches, nil
+	}
+	s := newFileScan(q, before, after)
+	if err := scanLines(ctx, r, buf, overlap, s.piece); err != nil {
+		return false, nil, err
+	}
+	s.finish()
+	return s.found, s.matches, nil
+}
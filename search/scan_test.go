@@ -0,0 +1,170 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// scanString searches content for a keyword, literal or a regular
+// expression, and renders the matches as "line:column@offset text", the
+// text left out if it is long, followed by the lines of their context
+func scanString(t *testing.T, content, keyword string, regex bool, before, after int) []string {
+	t.Helper()
+	mode := matchMode{matcher: newLiteral}
+	if regex {
+		mode.matcher = newRegex
+	}
+	q, err := singleQuery(keyword, ScopeFile, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewind := func() (io.Reader, error) { return strings.NewReader(content), nil }
+	_, matches, err := scanContent(context.Background(), strings.NewReader(content), rewind, make([]byte, chunkSize), q, before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range matches {
+		s := fmt.Sprintf("%d:%d@%d", m.Line, m.Column, m.Offset)
+		if len(m.Text) <= 20 {
+			s += " " + m.Text
+		}
+		for _, c := range m.Before {
+			s += fmt.Sprintf(" -%d", c.Line)
+		}
+		for _, c := range m.After {
+			s += fmt.Sprintf(" +%d", c.Line)
+		}
+		got = append(got, s)
+	}
+	return got
+}
+
+// shortLines returns lines of 9 bytes, newline included, up to n bytes
+func shortLines(n int) string {
+	return strings.Repeat("xxxxxxxx\n", n/9)
+}
+
+func TestScanBufferBoundary(t *testing.T) {
+	// 7281 short lines end at offset 65529, so that the line after them
+	// crosses the end of the first buffer
+	lines := shortLines(65529)
+	long := strings.Repeat("x", chunkSize-3)
+
+	tests := []struct {
+		name    string
+		content string
+		keyword string
+		regex   bool
+		want    []string
+	}{
+		{
+			name:    "short line across the buffer",
+			content: lines + "abcneedle\n",
+			keyword: "needle",
+			want:    []string{"7282:4@65532 abcneedle"},
+		},
+		{
+			name:    "long line, match across the cut",
+			content: long + "needle" + long + "needle\n",
+			keyword: "needle",
+			want: []string{
+				fmt.Sprintf("1:%d@%d", chunkSize-2, chunkSize-3),
+				fmt.Sprintf("1:%d@%d", 2*chunkSize+1, 2*chunkSize),
+			},
+		},
+		{
+			name:    "long line, match in the overlap reported once",
+			content: strings.Repeat("x", chunkSize-5) + "needle" + long,
+			keyword: "needle",
+			want:    []string{fmt.Sprintf("1:%d@%d", chunkSize-4, chunkSize-5)},
+		},
+		{
+			name:    "long line of multibyte characters",
+			content: strings.Repeat("é", chunkSize) + "needle\nnext\n",
+			keyword: "needle",
+			want:    []string{fmt.Sprintf("1:%d@%d", chunkSize+1, 2*chunkSize)},
+		},
+		{
+			name:    "regex across the cut",
+			content: long + "ne+dle\n",
+			keyword: `ne\+dle`,
+			regex:   true,
+			want:    []string{fmt.Sprintf("1:%d@%d", chunkSize-2, chunkSize-3)},
+		},
+		{
+			name:    "line after a long line",
+			content: long + long + "\nneedle\n",
+			keyword: "needle",
+			want:    []string{fmt.Sprintf("2:1@%d needle", 2*len(long)+1)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := scanString(t, tt.content, tt.keyword, tt.regex, 0, 0)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanCRLF(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyword string
+		regex   bool
+		want    []string
+	}{
+		{"literal", "needle", false, []string{"2:5@9 two needle", "4:1@24 needle"}},
+		{"end of line", "needle$", true, []string{"2:5@9 two needle", "4:1@24 needle"}},
+		{"carriage return not matched", `e\r`, true, nil},
+	}
+	content := "one\r\ntwo needle\r\nthree\r\nneedle"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := scanString(t, content, tt.keyword, tt.regex, 0, 0)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanContext(t *testing.T) {
+	tests := []struct {
+		name          string
+		matchLines    []int
+		before, after int
+		want          []string
+	}{
+		{"apart", []int{3, 9}, 1, 1, []string{"3:1@6 m3 -2 +4", "9:1@24 m9 -8 +10"}},
+		{"context shared", []int{4, 6}, 2, 2, []string{"4:1@9 m4 -2 -3 +5", "6:1@15 m6 +7 +8"}},
+		{"adjacent", []int{4, 5}, 2, 2, []string{"4:1@9 m4 -2 -3", "5:1@12 m5 +6 +7"}},
+		{"at the edges", []int{1, 10}, 3, 3, []string{"1:1@0 m1 +2 +3 +4", "10:1@27 m10 -7 -8 -9"}},
+		{"before only", []int{5}, 2, 0, []string{"5:1@12 m5 -3 -4"}},
+		{"after only", []int{5}, 0, 2, []string{"5:1@12 m5 +6 +7"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// ten lines, those matching starting with "m"
+			var b strings.Builder
+			for line := 1; line <= 10; line++ {
+				prefix := "l"
+				for _, m := range tt.matchLines {
+					if m == line {
+						prefix = "m"
+					}
+				}
+				fmt.Fprintf(&b, "%s%d\n", prefix, line)
+			}
+			got := scanString(t, b.String(), "m", false, tt.before, tt.after)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
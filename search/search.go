@@ -0,0 +1,262 @@
+// Package search finds files whose names or contents match a keyword, a
+// regular expression or a boolean query. Terms are found by a Matcher:
+// literal, regex and fuzzy are built in, and RegisterMatcher adds others.
+// A Searcher walks one or more directory trees with a bounded pool of
+// workers and streams a Result for every match, skipped path and error,
+// followed by a Summary.
+//
+// The text of documents such as PDFs is extracted and searched instead of
+// their bytes, and their matches are located by page. The members of zip,
+// jar and tar archives are searched as files, at paths such as
+// release.tar!/etc/app.conf, down to Options.ArchiveDepth levels of
+// archives within archives.
+//
+// A Searcher holds no state between searches, so several searches can run
+// at the same time, with the same Searcher or different ones.
+package search
+
+import (
+	"context"
+	"fmt"
+	"regexp/syntax"
+	"time"
+)
+
+// Options configures a Searcher
+type Options struct {
+	Keyword         string              // keyword, regular expression or boolean query to search for
+	Regex           bool                // Keyword is an RE2 regular expression; same as Matcher "regex"
+	Matcher         string              // name of the registered matcher finding terms; MatchLiteral if empty
+	Distance        int                 // edits allowed by the fuzzy matcher; one per four runes if 0
+	IgnoreCase      bool                // match with Unicode case folding
+	Query           bool                // Keyword is a boolean query of AND, OR, NOT and parentheses
+	Scope           string              // where query terms must occur together; ScopeFile if empty
+	Before          int                 // context lines reported before each match
+	After           int                 // context lines reported after each match
+	MaxSize         int64               // largest file whose contents are searched, in bytes; 0 for no limit
+	Workers         int                 // files searched at the same time; the number of CPUs if 0
+	Follow          bool                // follow symbolic links, walking each file and folder once
+	MaxDepth        int                 // levels below the roots walked, 1 for the entries of the roots only; no limit if 0
+	MinDepth        int                 // levels below the roots walked without searching, 1 to leave out the roots themselves
+	CharDeviceBytes int64               // bytes read from each character device, which are skipped if 0
+	NoExtract       bool                // search documents such as PDFs as raw bytes rather than their extracted text
+	MaxDocumentSize int64               // largest document, or archive within an archive, read into memory to search it, in bytes; DefaultMaxDocumentSize if 0
+	ArchiveDepth    int                 // levels of archives whose members are searched, 2 for those within archives in the tree too; archives are searched as files if 0
+	SameDevice      bool                // do not walk into folders on other file systems than their root, like find -xdev
+	NoIgnore        bool                // do not skip paths listed in .gitignore, .ignore and .gosearchignore
+	Include         []string            // globs of files to search; a "!" prefix makes an exclude glob
+	Exclude         []string            // globs of files and folders to skip
+	Types           []string            // names of the file types to search
+	FileTypes       map[string]FileType // file types Types refers to; DefaultFileTypes() if nil
+	Strict          bool                // stop the search at the first path that cannot be walked or read
+	Sort            string              // send results in a stable order, see SortPath; as they come if empty
+	ReportAll       bool                // also send results for paths searched without a match
+}
+
+// DefaultMaxDocumentSize is the largest document read into memory, if
+// Options.MaxDocumentSize is 0
+const DefaultMaxDocumentSize = 64 << 20
+
+// Kind tells what a Result reports
+type Kind int
+
+// Kinds of result
+const (
+	KindMatch   Kind = iota // the name or contents matched
+	KindNoMatch             // searched without a match, sent only with Options.ReportAll
+	KindSkip                // left out of the search, see Result.Reason
+	KindError               // could not be walked or read, see Result.Err
+)
+
+// Reasons a path is skipped
+const (
+	SkipTooLarge     = "tooLarge"     // file larger than Options.MaxSize
+	SkipIgnored      = "ignored"      // listed in an ignore file
+	SkipFiltered     = "filtered"     // left out by Options.Include, Exclude or Types
+	SkipDanglingLink = "danglingLink" // followed link whose target does not exist
+	SkipLoop         = "loop"         // followed link to a folder that contains it
+	SkipDuplicate    = "duplicate"    // followed link to a file or folder already walked
+	SkipMountPoint   = "mountPoint"   // folder on another file system, with Options.SameDevice
+	SkipSymlink      = "symlink"      // link to a folder, or to nothing, without Options.Follow
+	SkipFIFO         = "fifo"         // named pipe, whose reads may block forever
+	SkipSocket       = "socket"       // Unix domain socket
+	SkipDevice       = "device"       // block device
+	SkipCharDevice   = "charDevice"   // character device, without Options.CharDeviceBytes
+	SkipIrregular    = "irregular"    // file of an unknown type
+)
+
+// Root is a file or folder to search. Roots sharing a label are counted
+// together in Summary.Roots; a root without a label is labelled with its
+// path.
+type Root struct {
+	Label   string
+	Path    string
+	Shallow bool // search a folder itself but not its contents, as for paths listed by find
+}
+
+// Result reports one walked path
+type Result struct {
+	Kind      Kind
+	Root      string // label of the root the path was found under
+	Path      string
+	Name      string
+	IsDir     bool
+	Size      int64
+	ModTime   time.Time
+	NameMatch bool       // the name matched
+	Matches   []Match    // occurrences in the contents, in order
+	Reason    string     // why the path was skipped
+	Err       *PathError // why the path could not be searched
+}
+
+// Counts are the numbers of paths a search went through
+type Counts struct {
+	FilesChecked   int // files visited
+	FoldersChecked int // folders visited
+	FilesFound     int // files whose name or contents matched
+	FoldersFound   int // folders whose name matched
+	Errors         int // paths that could not be walked or read
+}
+
+// RootSummary counts what a search did under the roots of a label
+type RootSummary struct {
+	Label string
+	Counts
+}
+
+// Summary counts what a search did. When the search stopped early, Err
+// tells why and the counts and LastPath show how far it got.
+type Summary struct {
+	Counts
+	ErrorsByType map[string]int // errors by kind, see PathError
+	Roots        []RootSummary  // counts by root label, in the order of the roots
+	Elapsed      time.Duration
+	Err          error  // why the search stopped early, if it did
+	LastPath     string // last path the walk reached
+}
+
+// Searcher searches directory trees with a compiled keyword or query
+type Searcher struct {
+	opts   Options
+	query  *query
+	filter *pathFilter
+}
+
+// New checks the options and compiles the keyword or query. A query that
+// cannot be parsed is reported as a *QueryError.
+func New(opts Options) (*Searcher, error) {
+	if opts.Keyword == "" {
+		return nil, fmt.Errorf("missing keyword to search")
+	}
+	switch opts.Scope {
+	case "":
+		opts.Scope = ScopeFile
+	case ScopeFile, ScopeParagraph, ScopeLine:
+	default:
+		return nil, fmt.Errorf("scope must be one of file, paragraph or line")
+	}
+	if opts.Before < 0 || opts.After < 0 {
+		return nil, fmt.Errorf("context line counts cannot be negative")
+	}
+	if opts.Workers < 0 {
+		return nil, fmt.Errorf("number of workers cannot be negative")
+	}
+	switch opts.Sort {
+	case "", SortPath, SortSize, SortModTime, SortMatches:
+	default:
+		return nil, fmt.Errorf("sort must be one of path, size, mtime or matches")
+	}
+	if opts.CharDeviceBytes < 0 {
+		return nil, fmt.Errorf("character device byte limit cannot be negative")
+	}
+	if opts.MaxDepth < 0 || opts.MinDepth < 0 {
+		return nil, fmt.Errorf("depths cannot be negative")
+	}
+	if opts.MaxDocumentSize < 0 {
+		return nil, fmt.Errorf("document size limit cannot be negative")
+	}
+	if opts.MaxDocumentSize == 0 {
+		opts.MaxDocumentSize = DefaultMaxDocumentSize
+	}
+	if opts.ArchiveDepth < 0 {
+		return nil, fmt.Errorf("archive depth cannot be negative")
+	}
+	if opts.MaxDepth > 0 && opts.MinDepth > opts.MaxDepth {
+		return nil, fmt.Errorf("min depth cannot be greater than max depth")
+	}
+	if opts.FileTypes == nil {
+		opts.FileTypes = DefaultFileTypes()
+	}
+
+	switch {
+	case opts.Regex && opts.Matcher != "" && opts.Matcher != MatchRegex:
+		return nil, fmt.Errorf("regex option conflicts with matcher %q", opts.Matcher)
+	case opts.Regex:
+		opts.Matcher = MatchRegex
+	case opts.Matcher == "":
+		opts.Matcher = MatchLiteral
+	}
+	matcher, err := lookupMatcher(opts.Matcher)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Searcher{opts: opts}
+	mode := matchMode{matcher: matcher, ignoreCase: opts.IgnoreCase, distance: opts.Distance}
+	if opts.Query {
+		s.query, err = parseQuery(opts.Keyword, opts.Scope, mode)
+	} else {
+		s.query, err = singleQuery(opts.Keyword, opts.Scope, mode)
+	}
+	if serr, ok := err.(*syntax.Error); ok {
+		return nil, fmt.Errorf("invalid regular expression: %v", serr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.filter, err = newPathFilter(opts.Include, opts.Exclude, opts.Types, opts.FileTypes); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Run is a search in progress
+type Run struct {
+	results chan Result
+	done    chan struct{}
+	summary Summary
+}
+
+// Results returns the channel the search sends its results to. It is closed
+// when the search ends, and must be drained for the search to end:
+// cancelling the context stops the search early, but the results found
+// until then are still sent.
+func (r *Run) Results() <-chan Result {
+	return r.results
+}
+
+// Summary waits for the search to end and returns its summary
+func (r *Run) Summary() Summary {
+	<-r.done
+	return r.summary
+}
+
+// Search starts searching the given files and directory trees, one after the
+// other. Cancelling ctx stops the search; the summary then reports ctx.Err().
+func (s *Searcher) Search(ctx context.Context, paths ...string) *Run {
+	roots := make([]Root, len(paths))
+	for i, p := range paths {
+		roots[i] = Root{Path: p}
+	}
+	return s.SearchRoots(ctx, roots)
+}
+
+// SearchRoots is like Search, for labelled roots
+func (s *Searcher) SearchRoots(ctx context.Context, roots []Root) *Run {
+	r := &Run{
+		results: make(chan Result),
+		done:    make(chan struct{}),
+	}
+	go s.run(ctx, r, roots)
+	return r
+}
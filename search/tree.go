@@ -0,0 +1,132 @@
+package search
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// walkTree walks the tree at path like filepath.Walk, calling fn for every
+// file and folder in lexical order. With Options.Follow, symbolic links are
+// replaced by their targets before fn sees them, and a link whose target
+// does not exist is reported as skipped. Without it, only links to files
+// are replaced, so that they are searched as the files themselves; links
+// to folders, and links whose target cannot be found, are left as links.
+func (w *walker) walkTree(path string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Stat(path)
+		switch {
+		case !w.s.opts.Follow:
+			if err == nil && !target.IsDir() {
+				info = target
+			}
+		case os.IsNotExist(err):
+			w.skip(path, info, SkipDanglingLink)
+			return nil
+		case err != nil:
+			return fn(path, info, err)
+		default:
+			info = target
+		}
+	}
+
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+	if err := fn(path, info, nil); err != nil {
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+
+	// while its contents are walked, the folder is an ancestor that a link
+	// must not lead back to
+	if id, ok := idOf(info); ok && w.s.opts.Follow {
+		w.ancestors[id] = true
+		defer delete(w.ancestors, id)
+	}
+	names, err := readDirNames(path)
+	if err != nil {
+		return fn(path, info, err)
+	}
+	for _, name := range names {
+		filename := filepath.Join(path, name)
+		fileInfo, err := os.Lstat(filename)
+		if err != nil {
+			if err := fn(filename, fileInfo, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		if err := w.walkTree(filename, fileInfo, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seenBefore tells whether the file or folder behind a followed path was
+// already walked, and why it must not be walked again: SkipLoop for a
+// folder that contains the path, SkipDuplicate otherwise
+func (w *walker) seenBefore(info os.FileInfo) (string, bool) {
+	id, ok := idOf(info)
+	if !ok {
+		return "", false
+	}
+	if w.ancestors[id] {
+		return SkipLoop, true
+	}
+	if w.seen[id] {
+		return SkipDuplicate, true
+	}
+	w.seen[id] = true
+	return "", false
+}
+
+// readDirNames reads the folder and returns a sorted list of its entries
+func readDirNames(dirname string) ([]string, error) {
+	f, err := os.Open(dirname)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// specialFile returns why a file that is not regular must not be opened:
+// a link to a folder left unfollowed, or a file that may block or never
+// end. Character
+// devices are searched when deviceBytes allows reading from them.
+func specialFile(info os.FileInfo, deviceBytes int64) string {
+	mode := info.Mode()
+	switch {
+	case mode&os.ModeSymlink != 0:
+		return SkipSymlink
+	case mode&os.ModeNamedPipe != 0:
+		return SkipFIFO
+	case mode&os.ModeSocket != 0:
+		return SkipSocket
+	case mode&os.ModeCharDevice != 0:
+		if deviceBytes > 0 {
+			return ""
+		}
+		return SkipCharDevice
+	case mode&os.ModeDevice != 0:
+		return SkipDevice
+	case mode&os.ModeIrregular != 0:
+		return SkipIrregular
+	}
+	return ""
+}
+
+// isCharDevice tells whether a file is a character device, such as a
+// terminal or /dev/zero
+func isCharDevice(info os.FileInfo) bool {
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,64 @@
+package search
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosearch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{"a.txt": "x", "sub/b.txt": "x"})
+	for link, target := range map[string]string{"link.txt": "a.txt", "dirlink": "sub", "dangling": "nothing"} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Skip(err)
+		}
+	}
+
+	tests := []struct {
+		follow bool
+		want   []string
+	}{
+		{false, []string{"a.txt", "dangling: symlink", "dirlink: symlink", "link.txt", "sub/b.txt"}},
+		// dirlink is walked before sub, which is then a duplicate folder
+		{true, []string{"a.txt", "dangling: danglingLink", "dirlink/b.txt", "link.txt: duplicate"}},
+	}
+	for _, tt := range tests {
+		name := "unfollowed"
+		if tt.follow {
+			name = "followed"
+		}
+		t.Run(name, func(t *testing.T) {
+			s, err := New(Options{Keyword: "x", Follow: tt.follow, ReportAll: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			run := s.Search(context.Background(), dir)
+			var got []string
+			for res := range run.Results() {
+				if res.IsDir {
+					continue
+				}
+				rel, _ := filepath.Rel(dir, res.Path)
+				rel = filepath.ToSlash(rel)
+				if res.Kind == KindSkip {
+					rel += ": " + res.Reason
+				}
+				got = append(got, rel)
+			}
+			run.Summary()
+			sort.Strings(got)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
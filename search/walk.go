@@ -0,0 +1,452 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// job is a walked entry waiting for a worker to search it
+type job struct {
+	root   int // index into Summary.Roots
+	slot   int // place of the results with Options.Sort
+	path   string
+	f      os.FileInfo
+	search bool // search contents as well as name
+
+	// an archive filtered out, opened only for the members the filters let
+	// through
+	membersOnly bool
+}
+
+// walker is the state of one search
+type walker struct {
+	s       *Searcher
+	ctx     context.Context
+	cancel  context.CancelFunc
+	results chan Result
+	wg      sync.WaitGroup // workers
+	lock    sync.Mutex     // guards summary
+	summary Summary
+
+	order *orderer // sends results in order, with Options.Sort
+
+	// written by the walk only
+	root      int             // index into Summary.Roots of the root walked
+	lastPath  string          // last path walked
+	seen      map[fileID]bool // files and folders walked, with Options.Follow
+	ancestors map[fileID]bool // folders whose contents are being walked
+}
+
+// run walks the roots, hands entries to a fixed pool of workers and closes
+// the results once they are done. The walk blocks while every worker is
+// busy, and workers block while results are not consumed, so the number of
+// open files and read buffers never exceeds the number of workers.
+func (s *Searcher) run(parent context.Context, r *Run, roots []Root) {
+	start := time.Now()
+	ctx, cancel := context.WithCancel(parent)
+	w := &walker{
+		s:       s,
+		ctx:     ctx,
+		cancel:  cancel,
+		results: r.results,
+		summary: Summary{ErrorsByType: make(map[string]int)},
+
+		seen:      make(map[fileID]bool),
+		ancestors: make(map[fileID]bool),
+	}
+
+	// roots sharing a label share their counts
+	groups := make([]int, len(roots))
+	labels := make(map[string]int)
+	for i, root := range roots {
+		label := root.Label
+		if label == "" {
+			label = root.Path
+		}
+		g, ok := labels[label]
+		if !ok {
+			g = len(w.summary.Roots)
+			labels[label] = g
+			w.summary.Roots = append(w.summary.Roots, RootSummary{Label: label})
+		}
+		groups[i] = g
+	}
+
+	workers := s.opts.Workers
+	if workers == 0 {
+		workers = runtime.NumCPU()
+	}
+	if s.opts.Sort != "" {
+		w.order = newOrderer(w, s.opts.Sort, workers)
+	}
+	jobs := make(chan job, workers)
+	for i := 0; i < workers; i++ {
+		w.wg.Add(1)
+		go w.work(jobs)
+	}
+	for i, root := range roots {
+		w.root = groups[i]
+		if w.walk(root, jobs) != nil {
+			break
+		}
+	}
+	close(jobs)
+	w.wg.Wait()
+	if w.order != nil {
+		w.order.close()
+	}
+
+	if w.summary.Err == nil && parent.Err() != nil {
+		w.summary.Err = parent.Err()
+	}
+	cancel()
+	w.summary.LastPath = w.lastPath
+	w.summary.Elapsed = time.Since(start)
+	r.summary = w.summary
+	close(r.results)
+	close(r.done)
+}
+
+// send hands a result to the consumer. Results found before the search was
+// cancelled are still sent, so that they match the counts of the summary.
+func (w *walker) send(res Result) {
+	w.results <- res
+}
+
+// count updates the totals and the counts of a root
+func (w *walker) count(root int, update func(c *Counts)) {
+	w.lock.Lock()
+	update(&w.summary.Counts)
+	update(&w.summary.Roots[root].Counts)
+	w.lock.Unlock()
+}
+
+// emit reports a path skipped or failed by the walk
+func (w *walker) emit(res Result) {
+	if w.order == nil {
+		w.send(res)
+		return
+	}
+	if slot, err := w.order.slot(w.ctx); err == nil {
+		w.order.finish(slot, []Result{res})
+	}
+}
+
+// fail records an error on a path under a root and returns its result. In
+// strict mode the first error stops the search.
+func (w *walker) fail(root int, path string, op string, err error) Result {
+	e := &PathError{path, op, errorKind(err), err}
+	w.count(root, func(c *Counts) { c.Errors++ })
+	w.lock.Lock()
+	w.summary.ErrorsByType[e.Kind]++
+	if w.s.opts.Strict && w.summary.Err == nil {
+		w.summary.Err = e
+	}
+	w.lock.Unlock()
+	if w.s.opts.Strict {
+		w.cancel()
+	}
+	return Result{Kind: KindError, Root: w.label(root), Path: path, Name: filepath.Base(path), Err: e}
+}
+
+// label returns the label of a root
+func (w *walker) label(root int) string {
+	return w.summary.Roots[root].Label
+}
+
+// skip reports a path left out of the search
+func (w *walker) skip(path string, f os.FileInfo, reason string) {
+	w.emit(w.skipped(w.root, path, f, reason))
+}
+
+// skipped returns the result of a path left out of the search
+func (w *walker) skipped(root int, path string, f os.FileInfo, reason string) Result {
+	res := Result{Kind: KindSkip, Root: w.label(root), Path: path, Name: filepath.Base(path), IsDir: f.IsDir(), Reason: reason}
+	if !f.IsDir() {
+		res.Size = f.Size()
+		res.ModTime = f.ModTime()
+	}
+	return res
+}
+
+// filtered applies the include, exclude and type filters to a file, which
+// is only opened to read its "#!" line if regular. An archive they leave
+// out is still opened for its members, unless an exclude pattern names it.
+func (w *walker) filtered(path, rel string, regular, archive bool) (skip, membersOnly bool) {
+	if !w.s.filter.skipFile(path, rel, regular) {
+		return false, false
+	}
+	if archive && !w.s.filter.skipDir(rel) {
+		return false, true
+	}
+	return true, false
+}
+
+// walk walks all files and sub-directory paths of a root, or only the root
+// if it is shallow
+func (w *walker) walk(r Root, jobs chan<- job) error {
+	root := r.Path
+	opts := w.s.opts
+	var ignores *ignorer
+	if !opts.NoIgnore {
+		ignores = newIgnorer(root)
+	}
+
+	rootDevice, hasDevice := deviceOf(root)
+
+	visit := func(path string, f os.FileInfo, err error) error {
+		// record the error and carry on; for a folder that cannot be
+		// listed, returning nil skips its contents
+		if err != nil {
+			w.emit(w.fail(w.root, path, "walk", err))
+			return nil
+		}
+		if w.ctx.Err() != nil {
+			return w.ctx.Err()
+		}
+		w.lastPath = path
+
+		// skip ignored paths, and never descend into ignored folders
+		if ignores != nil {
+			if path != root && ignores.ignored(path, f.IsDir()) {
+				w.skip(path, f, SkipIgnored)
+				if f.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if f.IsDir() {
+				ignores.load(path)
+			}
+		}
+
+		// never open links left unfollowed, or files that may block or
+		// never end, such as FIFOs and devices, unless asked to; the
+		// type filter would otherwise open them to read a "#!" line
+		if reason := specialFile(f, opts.CharDeviceBytes); reason != "" {
+			w.skip(path, f, reason)
+			return nil
+		}
+
+		// apply include, exclude and type filters before opening files
+		rel, _ := filepath.Rel(root, path)
+		rel = filepath.ToSlash(rel)
+		membersOnly := false
+		if path != root {
+			if f.IsDir() && w.s.filter.skipDir(rel) {
+				w.skip(path, f, SkipFiltered)
+				return filepath.SkipDir
+			}
+			if !f.IsDir() {
+				var skip bool
+				if skip, membersOnly = w.filtered(path, rel, f.Mode().IsRegular(), w.isArchive(path, f)); skip {
+					w.skip(path, f, SkipFiltered)
+					return nil
+				}
+			}
+		}
+
+		// stay on the file system of the root
+		if opts.SameDevice && f.IsDir() && path != root && hasDevice {
+			if dev, ok := deviceOf(path); ok && dev != rootDevice {
+				w.skip(path, f, SkipMountPoint)
+				return filepath.SkipDir
+			}
+		}
+
+		// walk each file and folder behind followed links once
+		if opts.Follow {
+			if reason, seen := w.seenBefore(f); seen {
+				w.skip(path, f, reason)
+				if f.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		// walk folders shallower than MinDepth without searching them, and
+		// do not descend below MaxDepth
+		depth := 0
+		if path != root {
+			depth = strings.Count(rel, "/") + 1
+		}
+		var descend error
+		if f.IsDir() && (opts.MaxDepth > 0 && depth >= opts.MaxDepth || r.Shallow) {
+			descend = filepath.SkipDir
+		}
+		if depth < opts.MinDepth {
+			return descend
+		}
+
+		// only search contents of files under the size limit
+		search := false
+		if f.IsDir() {
+			w.count(w.root, func(c *Counts) { c.FoldersChecked++ })
+		} else {
+			w.count(w.root, func(c *Counts) { c.FilesChecked++ })
+			if opts.MaxSize <= 0 || f.Size() <= opts.MaxSize || isCharDevice(f) {
+				search = true
+			} else {
+				w.skip(path, f, SkipTooLarge)
+			}
+		}
+
+		slot := 0
+		if w.order != nil {
+			if slot, err = w.order.slot(w.ctx); err != nil {
+				return err
+			}
+		}
+		select {
+		case jobs <- job{w.root, slot, path, f, search, membersOnly}:
+			return descend
+		case <-w.ctx.Done():
+			if w.order != nil {
+				w.order.finish(slot, nil)
+			}
+			return w.ctx.Err()
+		}
+	}
+
+	// the root is followed even when links are not
+	info, err := os.Stat(root)
+	if err != nil {
+		return visit(root, nil, err)
+	}
+	return w.walkTree(root, info, visit)
+}
+
+// work searches walked entries until the walk is done, reusing one read
+// buffer
+func (w *walker) work(jobs <-chan job) {
+	defer w.wg.Done()
+	buf := make([]byte, chunkSize)
+	for j := range jobs {
+		if w.ctx.Err() != nil {
+			// the slot must still be finished, or the results of later
+			// slots are held back for good
+			if w.order != nil {
+				w.order.finish(j.slot, nil)
+			}
+			continue
+		}
+		results := w.search(j, buf)
+		if w.order != nil {
+			w.order.finish(j.slot, results)
+			continue
+		}
+		for _, res := range results {
+			w.send(res)
+		}
+	}
+}
+
+// search matches the name of an entry and the contents of a file, or the
+// members of an archive, and returns the results to report
+func (w *walker) search(j job, buf []byte) []Result {
+	var contents func() (bool, []Match, []Result, *PathError)
+	if j.search {
+		contents = func() (bool, []Match, []Result, *PathError) {
+			if w.isArchive(j.path, j.f) {
+				members, err := w.searchArchive(j, buf)
+				return false, nil, members, err
+			}
+			found, matches, err := w.searchFile(j.path, j.f, buf)
+			return found, matches, nil, err
+		}
+	}
+	return w.searchEntry(j.root, j.path, j.f.Name(), j.f, j.membersOnly, contents)
+}
+
+// searchEntry matches the name of a walked entry or of an archive member,
+// and its contents if contents is not nil, and returns the results to
+// report: an error, its own result, then those of the members of an
+// archive that were not sent already. With membersOnly, the entry itself is
+// neither matched nor reported.
+func (w *walker) searchEntry(root int, path, name string, f os.FileInfo, membersOnly bool, contents func() (bool, []Match, []Result, *PathError)) []Result {
+	res := Result{
+		Kind:    KindNoMatch,
+		Root:    w.label(root),
+		Path:    path,
+		Name:    name,
+		IsDir:   f.IsDir(),
+		Size:    f.Size(),
+		ModTime: f.ModTime(),
+	}
+	if !membersOnly {
+		res.NameMatch = w.s.query.matchName([]byte(res.Name))
+	}
+	found := res.NameMatch
+	var results, members []Result
+	if contents != nil {
+		content, matches, inner, err := contents()
+		if err != nil && w.ctx.Err() != nil {
+			// stopped part way through the file, or through the members
+			// of an archive, whose results so far are still reported
+			return inner
+		}
+		if err != nil {
+			results = append(results, w.fail(root, path, err.Op, err.Err))
+		}
+		found = found || content
+		res.Matches = matches
+		members = inner
+	}
+	if found {
+		res.Kind = KindMatch
+		if res.IsDir {
+			w.count(root, func(c *Counts) { c.FoldersFound++ })
+		} else {
+			w.count(root, func(c *Counts) { c.FilesFound++ })
+		}
+	}
+	if (found || w.s.opts.ReportAll) && !membersOnly {
+		results = append(results, res)
+	}
+	return append(results, members...)
+}
+
+// searchFile scans the contents of a file looking for keyword, one buffer
+// at a time. A character device is read once, up to
+// Options.CharDeviceBytes, as it may never end and cannot be rewound. The
+// text of a document, such as a PDF, is extracted and searched instead of
+// its bytes, unless the file does not start like one.
+func (w *walker) searchFile(path string, info os.FileInfo, buf []byte) (bool, []Match, *PathError) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, nil, &PathError{Op: "open", Err: err}
+	}
+	defer file.Close()
+	if format, ok := documentFormat(path); ok && !w.s.opts.NoExtract && info.Mode().IsRegular() && format.holds(file) {
+		return w.searchDocument(file, info.Size(), format.extract, buf)
+	}
+	var r io.Reader = file
+	rewind := func() (io.Reader, error) {
+		_, err := file.Seek(0, io.SeekStart)
+		return file, err
+	}
+	if isCharDevice(info) {
+		data, err := ioutil.ReadAll(io.LimitReader(file, w.s.opts.CharDeviceBytes))
+		if err != nil {
+			return false, nil, &PathError{Op: "read", Err: err}
+		}
+		r = bytes.NewReader(data)
+		rewind = func() (io.Reader, error) {
+			return bytes.NewReader(data), nil
+		}
+	}
+	found, matches, err := scanContent(w.ctx, r, rewind, buf, w.s.query, w.s.opts.Before, w.s.opts.After)
+	if err != nil {
+		return false, nil, &PathError{Op: "read", Err: err}
+	}
+	return found, matches, nil
+}
@@ -0,0 +1,379 @@
+package search
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+	"unicode/utf16"
+)
+
+// BIFF record types
+const (
+	biffFormula    = 0x0006
+	biffEOF        = 0x000a
+	biffFilePass   = 0x002f
+	biffContinue   = 0x003c
+	biffBoundSheet = 0x0085
+	biffMulRK      = 0x00bd
+	biffRString    = 0x00d6
+	biffSST        = 0x00fc
+	biffLabelSST   = 0x00fd
+	biffNumber     = 0x0203
+	biffLabel      = 0x0204
+	biffBoolErr    = 0x0205
+	biffString     = 0x0207
+	biffRK         = 0x027e
+	biffBOF        = 0x0809
+)
+
+// biffErrors are the values of error cells
+var biffErrors = map[byte]string{
+	0x00: "#NULL!",
+	0x07: "#DIV/0!",
+	0x0f: "#VALUE!",
+	0x17: "#REF!",
+	0x1d: "#NAME?",
+	0x24: "#NUM!",
+	0x2a: "#N/A",
+}
+
+// extractXLS writes the value of each cell of an Excel 95-2003 workbook,
+// in a section named by the sheet and the cell like those of xlsx files.
+// The workbook stream is a sequence of BIFF records: the workbook globals,
+// with the sheet names and the shared strings, then the cells of each sheet.
+func extractXLS(r io.ReaderAt, size int64, doc *document) error {
+	// its streams are read into memory whole
+	if err := doc.fits(size, "XLS"); err != nil {
+		return err
+	}
+	c, err := openCFB(r, size, "XLS")
+	if err != nil {
+		return err
+	}
+	stream, err := c.stream("Workbook")
+	if err == nil && stream == nil {
+		stream, err = c.stream("Book")
+	}
+	if err != nil {
+		return err
+	}
+	le := binary.LittleEndian
+	if len(stream) < 8 || le.Uint16(stream) != biffBOF {
+		return docError("XLS", "no BIFF5 or BIFF8 workbook stream")
+	}
+	x := &xlsBook{doc: doc, biff8: le.Uint16(stream[4:]) == 0x0600, sheets: make(map[int]string)}
+
+	for pos := 0; pos+4 <= len(stream); {
+		off, typ, n := pos, le.Uint16(stream[pos:]), int(le.Uint16(stream[pos+2:]))
+		pos += 4
+		if pos+n > len(stream) {
+			break
+		}
+		rec := &biffReader{segs: [][]byte{stream[pos : pos+n]}, biff8: x.biff8}
+		pos += n
+		// long records go on in CONTINUE records
+		for pos+4 <= len(stream) && le.Uint16(stream[pos:]) == biffContinue {
+			n := int(le.Uint16(stream[pos+2:]))
+			if pos+4+n > len(stream) {
+				break
+			}
+			rec.segs = append(rec.segs, stream[pos+4:pos+4+n])
+			pos += 4 + n
+		}
+		if err := x.record(off, typ, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// xlsBook is the state of the records read so far
+type xlsBook struct {
+	doc     *document
+	biff8   bool           // BIFF8, Excel 97 and later, rather than BIFF5
+	sheets  map[int]string // sheet names by the offset of their BOF record
+	count   int            // sheets seen
+	sheet   string         // current sheet, as written in cell references
+	shared  []string       // shared string table
+	pending []byte         // cell of a formula whose string value follows
+}
+
+func (x *xlsBook) record(off int, typ uint16, rec *biffReader) error {
+	le := binary.LittleEndian
+	data := rec.segs[0]
+	switch typ {
+	case biffFilePass:
+		return docError("XLS", "encrypted")
+	case biffBoundSheet:
+		pos := rec.read(6)
+		if len(pos) < 6 {
+			return nil
+		}
+		if name, ok := rec.str(1); ok {
+			x.sheets[int(le.Uint32(pos))] = name
+		}
+	case biffBOF:
+		// worksheets and macro sheets, not charts embedded in them
+		if len(data) < 4 || le.Uint16(data[2:]) != 0x0010 && le.Uint16(data[2:]) != 0x0040 {
+			return nil
+		}
+		x.count++
+		name, ok := x.sheets[off]
+		if !ok {
+			name = "Sheet" + strconv.Itoa(x.count)
+		}
+		x.sheet = sheetRef(name)
+	case biffEOF:
+		x.pending = nil
+	case biffSST:
+		if rec.read(8) == nil {
+			return nil
+		}
+		for {
+			s, ok := rec.richString()
+			if !ok {
+				break
+			}
+			x.shared = append(x.shared, s)
+		}
+	case biffLabelSST:
+		if len(data) >= 10 {
+			if n := int(le.Uint32(data[6:])); n < len(x.shared) {
+				x.cell(data, x.shared[n])
+			}
+		}
+	case biffLabel, biffRString:
+		if cell := rec.read(6); len(cell) == 6 {
+			if s, ok := rec.str(2); ok {
+				x.cell(cell, s)
+			}
+		}
+	case biffNumber:
+		if len(data) >= 14 {
+			x.cell(data, formatNumber(math.Float64frombits(le.Uint64(data[6:]))))
+		}
+	case biffRK:
+		if len(data) >= 10 {
+			x.cell(data, formatNumber(rkNumber(le.Uint32(data[6:]))))
+		}
+	case biffMulRK:
+		// a row, a first column, then the format and value of each cell
+		if len(data) < 6 {
+			return nil
+		}
+		cell := append([]byte(nil), data[:4]...)
+		col := le.Uint16(data[2:])
+		for i := 4; i+6 <= len(data)-2; i += 6 {
+			le.PutUint16(cell[2:], col)
+			x.cell(cell, formatNumber(rkNumber(le.Uint32(data[i+2:]))))
+			col++
+		}
+	case biffBoolErr:
+		if len(data) >= 8 {
+			x.cell(data, boolErr(data[6], data[7]))
+		}
+	case biffFormula:
+		// the cached value: a number, or a type marked by 0xffff at its end
+		if len(data) < 14 {
+			return nil
+		}
+		value := data[6:14]
+		if le.Uint16(value[6:]) != 0xffff {
+			x.cell(data, formatNumber(math.Float64frombits(le.Uint64(value))))
+			return nil
+		}
+		switch value[0] {
+		case 0:
+			x.pending = append([]byte(nil), data[:4]...)
+		case 1:
+			x.cell(data, boolErr(value[2], 0))
+		case 2:
+			x.cell(data, boolErr(value[2], 1))
+		}
+	case biffString:
+		if x.pending != nil {
+			if s, ok := rec.str(2); ok {
+				x.cell(x.pending, s)
+			}
+			x.pending = nil
+		}
+	}
+	return nil
+}
+
+// cell writes the text of the cell whose row and column begin data
+func (x *xlsBook) cell(data []byte, text string) {
+	if x.sheet == "" || text == "" {
+		return
+	}
+	le := binary.LittleEndian
+	row, col := int(le.Uint16(data)), int(le.Uint16(data[2:]))
+	x.doc.begin(x.sheet + "!" + columnName(col+1) + strconv.Itoa(row+1))
+	x.doc.WriteString(text)
+}
+
+// rkNumber decodes an RK number: an integer or the high bits of a float,
+// possibly multiplied by 100
+func rkNumber(rk uint32) float64 {
+	var f float64
+	if rk&0x02 != 0 {
+		f = float64(int32(rk) >> 2)
+	} else {
+		f = math.Float64frombits(uint64(rk&0xfffffffc) << 32)
+	}
+	if rk&0x01 != 0 {
+		f /= 100
+	}
+	return f
+}
+
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// boolErr returns the text of a boolean, or of an error if isErr is set
+func boolErr(value, isErr byte) string {
+	if isErr != 0 {
+		return biffErrors[value]
+	}
+	if value != 0 {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// biffReader reads the data of a record and of the CONTINUE records after
+// it as one
+type biffReader struct {
+	segs  [][]byte
+	pos   int // in segs[0]
+	biff8 bool
+}
+
+// read reads n bytes, or returns nil if there are fewer left
+func (b *biffReader) read(n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		if len(b.segs) == 0 {
+			return nil
+		}
+		seg := b.segs[0][b.pos:]
+		if len(seg) == 0 {
+			b.segs, b.pos = b.segs[1:], 0
+			continue
+		}
+		k := minInt(n-len(out), len(seg))
+		out = append(out, seg[:k]...)
+		b.pos += k
+	}
+	return out
+}
+
+// skip skips n bytes
+func (b *biffReader) skip(n int) bool {
+	for n > 0 {
+		if len(b.segs) == 0 {
+			return false
+		}
+		seg := b.segs[0][b.pos:]
+		if len(seg) == 0 {
+			b.segs, b.pos = b.segs[1:], 0
+			continue
+		}
+		k := minInt(n, len(seg))
+		b.pos += k
+		n -= k
+	}
+	return true
+}
+
+// str reads a string whose character count takes lenSize bytes. BIFF8
+// strings have a flags byte telling 8-bit text from UTF-16; BIFF5 strings
+// are in the ANSI code page.
+func (b *biffReader) str(lenSize int) (string, bool) {
+	h := b.read(lenSize)
+	if h == nil {
+		return "", false
+	}
+	n := int(h[0])
+	if lenSize == 2 {
+		n = int(binary.LittleEndian.Uint16(h))
+	}
+	high := false
+	if b.biff8 {
+		flags := b.read(1)
+		if flags == nil {
+			return "", false
+		}
+		high = flags[0]&0x01 != 0
+	}
+	return b.chars(n, high)
+}
+
+// richString reads a string of the shared string table, skipping its
+// formatting runs and phonetic data
+func (b *biffReader) richString() (string, bool) {
+	h := b.read(3)
+	if h == nil {
+		return "", false
+	}
+	le := binary.LittleEndian
+	n, flags := int(le.Uint16(h)), h[2]
+	runs, ext := 0, 0
+	if flags&0x08 != 0 {
+		r := b.read(2)
+		if r == nil {
+			return "", false
+		}
+		runs = int(le.Uint16(r))
+	}
+	if flags&0x04 != 0 {
+		r := b.read(4)
+		if r == nil {
+			return "", false
+		}
+		ext = int(le.Uint32(r))
+	}
+	s, ok := b.chars(n, flags&0x01 != 0)
+	if !ok || !b.skip(4*runs) || !b.skip(ext) {
+		return "", false
+	}
+	return s, true
+}
+
+// chars reads n characters, UTF-16 if high is set. When BIFF8 characters
+// go on in a CONTINUE record, it begins with a new flags byte, as the
+// characters may change size.
+func (b *biffReader) chars(n int, high bool) (string, bool) {
+	units := make([]uint16, 0, n)
+	for len(units) < n {
+		if len(b.segs) == 0 {
+			return "", false
+		}
+		seg := b.segs[0][b.pos:]
+		if len(seg) == 0 {
+			b.segs, b.pos = b.segs[1:], 0
+			if b.biff8 && len(b.segs) > 0 && len(b.segs[0]) > 0 {
+				high = b.segs[0][0]&0x01 != 0
+				b.pos = 1
+			}
+			continue
+		}
+		if high {
+			if len(seg) < 2 {
+				return "", false
+			}
+			units = append(units, binary.LittleEndian.Uint16(seg))
+			b.pos += 2
+			continue
+		}
+		c := rune(seg[0])
+		if !b.biff8 && c >= 0x80 && c < 0xa0 {
+			c = winAnsiEncoding[c]
+		}
+		units = append(units, uint16(c))
+		b.pos++
+	}
+	return string(utf16.Decode(units)), true
+}